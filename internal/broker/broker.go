@@ -0,0 +1,608 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// announceTimeout is how long Dial waits for the other side to announce
+	// a sub-connection, and how long an announcement waits to be dialed.
+	announceTimeout = 5 * time.Second
+)
+
+// stream is the broker stream as seen from either side.
+type stream interface {
+	Send(*ConnInfo) error
+	Recv() (*ConnInfo, error)
+}
+
+// Broker hands out numbered sub-connections between the runner and a
+// plugin, next to the plugin's main connection. One side accepts the
+// sub-connection with a given id, the other dials it with the same id. Every
+// sub-connection is a connection of its own, secured with mTLS like the main
+// one.
+//
+// Ids are agreed on out of band, usually one side picks one with NextID and
+// passes it to the other in a call on the main connection.
+type Broker struct {
+	logger *slog.Logger
+	// host is set for the runner's side of the broker.
+	host bool
+	// keyAndCert is the plugin's server certificate on the plugin side and
+	// the runner's client certificate for the plugin on the host side.
+	keyAndCert *transport.KeyAndCert
+	// issue issues the certificates of the listeners the host accepts on,
+	// nil if the runner cannot issue certificates.
+	issue  func(subject string, role transport.Role) (*transport.KeyAndCert, error)
+	nextID func() uint32
+
+	stream stream
+	// attached is closed once stream is set.
+	attached chan struct{}
+	sendMu   sync.Mutex
+
+	mu sync.Mutex
+	// announced holds the announcements of the other side until they are
+	// dialed, keyed by id.
+	announced  map[uint32]chan *ConnInfo
+	listeners  []net.Listener
+	hostServer *transport.KeyAndCert
+	// allowed holds, on the plugin side, the fingerprints of the client
+	// certificates the runner allowed on the listener of an id besides its
+	// own, keyed by id. allowedChanged is closed and replaced whenever one
+	// is added.
+	allowed        map[uint32][]string
+	allowedChanged chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	cancel    context.CancelFunc
+}
+
+func newBroker(logger *slog.Logger) *Broker {
+	return &Broker{
+		logger:    logger,
+		attached:  make(chan struct{}),
+		announced: make(map[uint32]chan *ConnInfo),
+		done:      make(chan struct{}),
+		cancel:    func() {},
+
+		allowed:        make(map[uint32][]string),
+		allowedChanged: make(chan struct{}),
+	}
+}
+
+// NewPluginBroker creates the plugin's side of the broker and registers the
+// broker service the runner connects to on registrar. keyAndCert is the
+// plugin's server certificate.
+func NewPluginBroker(registrar grpc.ServiceRegistrar, keyAndCert *transport.KeyAndCert) *Broker {
+	b := newBroker(slog.With("component", "broker"))
+	b.keyAndCert = keyAndCert
+	// Plugins pick odd ids, the runner even ones, so ids picked by the two
+	// sides never collide.
+	var ids atomic.Uint32
+	b.nextID = func() uint32 {
+		return 2*ids.Add(1) - 1
+	}
+	RegisterBrokerServer(registrar, &brokerServer{broker: b})
+	return b
+}
+
+// NewHostBroker opens the broker stream to the plugin named name on conn.
+// keyAndCert is the runner's client certificate for the plugin and issue, if
+// not nil, issues the certificates for the listeners the runner accepts on.
+// ids numbers the sub-connections of all plugins, so ids can be forwarded
+// between them.
+func NewHostBroker(ctx context.Context, name string, conn grpc.ClientConnInterface, keyAndCert *transport.KeyAndCert, issue func(subject string, role transport.Role) (*transport.KeyAndCert, error), ids *atomic.Uint32) (*Broker, error) {
+	b := newBroker(slog.With("component", "broker", "plugin", name))
+	b.host = true
+	b.keyAndCert = keyAndCert
+	b.issue = issue
+	b.nextID = func() uint32 {
+		return 2 * ids.Add(1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := NewBrokerClient(conn).StartStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrapf(err, "failed to open broker stream to plugin %s", name)
+	}
+	b.cancel = cancel
+	b.attach(stream)
+
+	go func() {
+		err := b.receive(stream)
+		if status.Code(err) == codes.Unimplemented {
+			b.closeWith(errors.Errorf("plugin %s does not serve the broker, rebuild it against a newer version of this library", name))
+			return
+		}
+		b.closeWith(errors.Wrap(err, "broker stream ended"))
+	}()
+	return b, nil
+}
+
+// brokerServer serves the broker stream on the plugin side.
+type brokerServer struct {
+	UnimplementedBrokerServer
+	broker *Broker
+}
+
+func (s *brokerServer) StartStream(stream Broker_StartStreamServer) error {
+	if !s.broker.attach(stream) {
+		return status.Error(codes.AlreadyExists, "broker stream already started")
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		received <- s.broker.receive(stream)
+	}()
+	select {
+	case err := <-received:
+		s.broker.closeWith(errors.Wrap(err, "broker stream ended"))
+	case <-s.broker.done:
+	}
+	return nil
+}
+
+// attach sets the stream announcements are sent on. It fails if there
+// already is one.
+func (b *Broker) attach(stream stream) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stream != nil {
+		return false
+	}
+	b.stream = stream
+	close(b.attached)
+	return true
+}
+
+// receive records the announcements of the other side until the stream
+// ends.
+func (b *Broker) receive(stream stream) error {
+	for {
+		info, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		id := info.GetId()
+		if fingerprint := info.GetAllowClient(); fingerprint != "" {
+			if b.host {
+				b.logger.Warn("plugin tried to allow a client certificate", "id", id)
+				continue
+			}
+			b.allowClient(id, fingerprint)
+			continue
+		}
+		announced := b.announcement(id)
+		select {
+		case announced <- info:
+		default:
+			b.logger.Warn("sub-connection announced twice", "id", id)
+			continue
+		}
+		b.logger.Debug("sub-connection announced", "id", id, "address", info.GetAddress())
+
+		// Drop announcements nobody dials.
+		time.AfterFunc(announceTimeout, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.announced[id] != announced {
+				return
+			}
+			select {
+			case <-announced:
+				delete(b.announced, id)
+			default:
+			}
+		})
+	}
+}
+
+// announcement returns the channel the announcement of id is delivered on.
+func (b *Broker) announcement(id uint32) chan *ConnInfo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	announced, ok := b.announced[id]
+	if !ok {
+		announced = make(chan *ConnInfo, 1)
+		b.announced[id] = announced
+	}
+	return announced
+}
+
+// allowClient lets the client certificate with fingerprint connect to the
+// listener for id.
+func (b *Broker) allowClient(id uint32, fingerprint string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.allowed[id] = append(b.allowed[id], fingerprint)
+	close(b.allowedChanged)
+	b.allowedChanged = make(chan struct{})
+	b.logger.Debug("client certificate allowed on sub-connection", "id", id)
+}
+
+// verifyClient returns the VerifyPeerCertificate callback of the plugin's
+// listener for id. It accepts the runner's client certificate and the ones
+// the runner allowed for id. The runner allows a certificate before the
+// plugin it forwards id to learns about it, but both messages travel on
+// different streams, so it waits up to announceTimeout for it to arrive.
+func (b *Broker) verifyClient(id uint32) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	verifyRunner := transport.VerifyFingerprint(b.keyAndCert.AllowedClients...)
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if verifyRunner(rawCerts, verifiedChains) == nil {
+			return nil
+		}
+
+		timer := time.NewTimer(announceTimeout)
+		defer timer.Stop()
+		for {
+			b.mu.Lock()
+			allowed := b.allowed[id]
+			changed := b.allowedChanged
+			b.mu.Unlock()
+
+			err := transport.VerifyFingerprint(allowed...)(rawCerts, verifiedChains)
+			if err == nil {
+				return nil
+			}
+			select {
+			case <-changed:
+			case <-timer.C:
+				return errors.Wrapf(err, "client certificate was not allowed on sub-connection %d", id)
+			case <-b.done:
+				return b.closeErr
+			}
+		}
+	}
+}
+
+// waitAnnouncement waits for the other side to announce id.
+func (b *Broker) waitAnnouncement(id uint32) (*ConnInfo, error) {
+	announced := b.announcement(id)
+	defer func() {
+		b.mu.Lock()
+		if b.announced[id] == announced {
+			delete(b.announced, id)
+		}
+		b.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(announceTimeout)
+	defer timer.Stop()
+	select {
+	case info := <-announced:
+		if info.GetNetwork() != "tcp" {
+			return nil, errors.Errorf("sub-connection %d uses unsupported network %q", id, info.GetNetwork())
+		}
+		return info, nil
+	case <-timer.C:
+		return nil, errors.Errorf("timed out waiting for sub-connection %d", id)
+	case <-b.done:
+		return nil, b.closeErr
+	}
+}
+
+// send announces info to the other side.
+func (b *Broker) send(info *ConnInfo) error {
+	timer := time.NewTimer(announceTimeout)
+	defer timer.Stop()
+	select {
+	case <-b.attached:
+	case <-timer.C:
+		return errors.New("timed out waiting for the broker stream")
+	case <-b.done:
+		return b.closeErr
+	}
+
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	if err := b.stream.Send(info); err != nil {
+		return errors.Wrapf(err, "failed to announce sub-connection %d", info.GetId())
+	}
+	return nil
+}
+
+// NextID returns an id no other sub-connection of this broker uses.
+func (b *Broker) NextID() uint32 {
+	return b.nextID()
+}
+
+// listen opens a listener for id and announces it to the other side. It
+// returns the TLS config connections to the listener must be served with.
+func (b *Broker) listen(id uint32) (net.Listener, *tls.Config, error) {
+	tlsConfig, clientKeyAndCert, err := b.listenerTLS(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to listen for sub-connection %d", id)
+	}
+
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		listener.Close()
+		return nil, nil, b.closeErr
+	default:
+	}
+	b.listeners = append(b.listeners, listener)
+	b.mu.Unlock()
+
+	err = b.send(&ConnInfo{
+		Id:         id,
+		Network:    "tcp",
+		Address:    listener.Addr().String(),
+		KeyAndCert: clientKeyAndCert,
+	})
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+	b.logger.Debug("accepting sub-connection", "id", id, "address", listener.Addr().String())
+	return listener, tlsConfig, nil
+}
+
+// listenerTLS returns the TLS config of a new listener for id together with
+// the serialized client certificate the other side dials it with, if any.
+func (b *Broker) listenerTLS(id uint32) (*tls.Config, []byte, error) {
+	if !b.host {
+		// The runner dials with its client certificate for the plugin,
+		// other plugins with the ones the runner allowed when forwarding id.
+		tlsConfig, err := b.keyAndCert.GetTLSConfig()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to get sub-connection TLS config")
+		}
+		// Runners built against older versions of this library do not tell
+		// the plugin their client certificate, any certificate of the CA
+		// is accepted then.
+		if len(b.keyAndCert.AllowedClients) > 0 {
+			tlsConfig.VerifyPeerCertificate = b.verifyClient(id)
+		}
+		return tlsConfig, nil, nil
+	}
+
+	if b.issue == nil {
+		return nil, nil, errors.New("accepting sub-connections in the runner requires ca_key_path when use_custom_tls is set")
+	}
+
+	b.mu.Lock()
+	serverKeyAndCert := b.hostServer
+	b.mu.Unlock()
+	if serverKeyAndCert == nil {
+		var err error
+		serverKeyAndCert, err = b.issue("host_broker", transport.RoleServer)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to issue sub-connection server certificate")
+		}
+		b.mu.Lock()
+		b.hostServer = serverKeyAndCert
+		b.mu.Unlock()
+	}
+
+	clientKeyAndCert, serialized, err := b.issueClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := serverKeyAndCert.GetTLSConfig()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get sub-connection TLS config")
+	}
+	// Only the plugin the listener was announced to may connect.
+	tlsConfig.VerifyPeerCertificate = transport.VerifyFingerprint(clientKeyAndCert.Fingerprint())
+	return tlsConfig, serialized, nil
+}
+
+// issueClient issues a client certificate for dialing a sub-connection.
+func (b *Broker) issueClient() (*transport.KeyAndCert, []byte, error) {
+	keyAndCert, err := b.issue("broker_client", transport.RoleClient)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to issue sub-connection client certificate")
+	}
+	serialized, err := keyAndCert.Serialize()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to serialize sub-connection client certificate")
+	}
+	return keyAndCert, serialized, nil
+}
+
+// dialerTLS returns the TLS config for dialing the sub-connection info
+// announces.
+func (b *Broker) dialerTLS(info *ConnInfo) (*tls.Config, error) {
+	keyAndCert := b.keyAndCert
+	if !b.host {
+		if len(info.GetKeyAndCert()) == 0 {
+			return nil, errors.Errorf("sub-connection %d was announced without a client certificate", info.GetId())
+		}
+		var err error
+		keyAndCert, err = transport.DeserializeKeyAndCert(info.GetKeyAndCert())
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to deserialize client certificate of sub-connection %d", info.GetId())
+		}
+	}
+	tlsConfig, err := keyAndCert.GetTLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sub-connection TLS config")
+	}
+	tlsConfig.ServerName = transport.ServerName
+	return tlsConfig, nil
+}
+
+// Accept announces the sub-connection id and returns a listener for it. The
+// connections it accepts are already secured with mTLS. The listener is
+// closed together with the broker.
+func (b *Broker) Accept(id uint32) (net.Listener, error) {
+	listener, tlsConfig, err := b.listen(id)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+// AcceptAndServe announces the sub-connection id and serves the gRPC server
+// returned by newServer on it until the broker is closed. newServer must
+// pass opts to grpc.NewServer.
+func (b *Broker) AcceptAndServe(id uint32, newServer func(opts []grpc.ServerOption) *grpc.Server) error {
+	listener, tlsConfig, err := b.listen(id)
+	if err != nil {
+		return err
+	}
+
+	server := newServer([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))})
+	go func() {
+		<-b.done
+		server.Stop()
+	}()
+	go func() {
+		err := server.Serve(listener)
+		select {
+		case <-b.done:
+		default:
+			if err != nil {
+				b.logger.Error("sub-connection server stopped", "id", id, "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Dial connects to the gRPC server the other side serves with
+// AcceptAndServe on the sub-connection id. It waits up to 5 seconds for the
+// other side to accept id.
+func (b *Broker) Dial(id uint32) (*grpc.ClientConn, error) {
+	info, err := b.waitAnnouncement(id)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := b.dialerTLS(info)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.NewClient(info.GetAddress(), grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create client for sub-connection %d", id)
+	}
+	b.logger.Debug("dialed sub-connection", "id", id, "address", info.GetAddress())
+	return conn, nil
+}
+
+// DialConn connects to the listener the other side returned from Accept for
+// the sub-connection id. It waits up to 5 seconds for the other side to
+// accept id.
+func (b *Broker) DialConn(id uint32) (net.Conn, error) {
+	info, err := b.waitAnnouncement(id)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := b.dialerTLS(info)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: announceTimeout},
+		Config:    tlsConfig,
+	}
+	conn, err := dialer.Dial("tcp", info.GetAddress())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial sub-connection %d", id)
+	}
+	b.logger.Debug("dialed sub-connection", "id", id, "address", info.GetAddress())
+	return conn, nil
+}
+
+// Forward lets the plugin of to dial the sub-connection id accepted by the
+// plugin of b, connecting the two plugins directly. It returns the id the
+// plugin of to dials. Only the runner can forward sub-connections.
+func (b *Broker) Forward(id uint32, to *Broker) (uint32, error) {
+	if !b.host || !to.host {
+		return 0, errors.New("only the runner can forward sub-connections")
+	}
+	if to.issue == nil {
+		return 0, errors.New("forwarding sub-connections requires ca_key_path when use_custom_tls is set")
+	}
+
+	info, err := b.waitAnnouncement(id)
+	if err != nil {
+		return 0, err
+	}
+	clientKeyAndCert, serialized, err := to.issueClient()
+	if err != nil {
+		return 0, err
+	}
+	// The plugin of b only lets the plugin of to connect once it knows the
+	// certificate it dials with.
+	err = b.send(&ConnInfo{
+		Id:          id,
+		AllowClient: clientKeyAndCert.Fingerprint(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	forwardedID := to.NextID()
+	err = to.send(&ConnInfo{
+		Id:         forwardedID,
+		Network:    info.GetNetwork(),
+		Address:    info.GetAddress(),
+		KeyAndCert: serialized,
+	})
+	if err != nil {
+		return 0, err
+	}
+	b.logger.Debug("forwarded sub-connection", "id", id, "forwarded_id", forwardedID)
+	return forwardedID, nil
+}
+
+// Done is closed once the broker is closed, e.g. because the plugin
+// instance it belongs to stopped.
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// Close closes the broker together with its listeners and the servers
+// started by AcceptAndServe. Connections returned by Dial and DialConn stay
+// open.
+func (b *Broker) Close() error {
+	b.closeWith(errors.New("broker is closed"))
+	return nil
+}
+
+// closeWith closes the broker, making pending and later calls fail with err.
+func (b *Broker) closeWith(err error) {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		b.closeErr = err
+		close(b.done)
+		listeners := b.listeners
+		b.listeners = nil
+		b.mu.Unlock()
+
+		b.cancel()
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		b.logger.Debug("broker closed", "reason", err)
+	})
+}
@@ -0,0 +1,173 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v6.31.1
+// source: broker.proto
+
+package broker
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// ConnInfo announces a listener accepting the sub-connection with the given
+// id.
+type ConnInfo struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Network is "tcp".
+	Network string `protobuf:"bytes,2,opt,name=network,proto3" json:"network,omitempty"`
+	Address string `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	// KeyAndCert is the serialized client certificate to present when dialing
+	// the listener. Only the runner sends one, plugins accept the runner's
+	// client certificate and the ones sent in allow_client.
+	KeyAndCert []byte `protobuf:"bytes,4,opt,name=key_and_cert,json=keyAndCert,proto3" json:"key_and_cert,omitempty"`
+	// AllowClient is sent by the runner instead of an announcement when it
+	// forwards the sub-connection id the plugin accepts. It is the fingerprint
+	// of the client certificate the plugin the sub-connection is forwarded to
+	// dials with, which may connect to the listener from then on.
+	AllowClient   string `protobuf:"bytes,5,opt,name=allow_client,json=allowClient,proto3" json:"allow_client,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConnInfo) Reset() {
+	*x = ConnInfo{}
+	mi := &file_broker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConnInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnInfo) ProtoMessage() {}
+
+func (x *ConnInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_broker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnInfo.ProtoReflect.Descriptor instead.
+func (*ConnInfo) Descriptor() ([]byte, []int) {
+	return file_broker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ConnInfo) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ConnInfo) GetNetwork() string {
+	if x != nil {
+		return x.Network
+	}
+	return ""
+}
+
+func (x *ConnInfo) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *ConnInfo) GetKeyAndCert() []byte {
+	if x != nil {
+		return x.KeyAndCert
+	}
+	return nil
+}
+
+func (x *ConnInfo) GetAllowClient() string {
+	if x != nil {
+		return x.AllowClient
+	}
+	return ""
+}
+
+var File_broker_proto protoreflect.FileDescriptor
+
+const file_broker_proto_rawDesc = "" +
+	"\n" +
+	"\fbroker.proto\x12\x14grpcplugin.broker.v1\"\x93\x01\n" +
+	"\bConnInfo\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\rR\x02id\x12\x18\n" +
+	"\anetwork\x18\x02 \x01(\tR\anetwork\x12\x18\n" +
+	"\aaddress\x18\x03 \x01(\tR\aaddress\x12 \n" +
+	"\fkey_and_cert\x18\x04 \x01(\fR\n" +
+	"keyAndCert\x12!\n" +
+	"\fallow_client\x18\x05 \x01(\tR\vallowClient2[\n" +
+	"\x06Broker\x12Q\n" +
+	"\vStartStream\x12\x1e.grpcplugin.broker.v1.ConnInfo\x1a\x1e.grpcplugin.broker.v1.ConnInfo(\x010\x01B1Z/github.com/trustdsh/grpc-plugin/internal/brokerb\x06proto3"
+
+var (
+	file_broker_proto_rawDescOnce sync.Once
+	file_broker_proto_rawDescData []byte
+)
+
+func file_broker_proto_rawDescGZIP() []byte {
+	file_broker_proto_rawDescOnce.Do(func() {
+		file_broker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_broker_proto_rawDesc), len(file_broker_proto_rawDesc)))
+	})
+	return file_broker_proto_rawDescData
+}
+
+var file_broker_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_broker_proto_goTypes = []any{
+	(*ConnInfo)(nil), // 0: grpcplugin.broker.v1.ConnInfo
+}
+var file_broker_proto_depIdxs = []int32{
+	0, // 0: grpcplugin.broker.v1.Broker.StartStream:input_type -> grpcplugin.broker.v1.ConnInfo
+	0, // 1: grpcplugin.broker.v1.Broker.StartStream:output_type -> grpcplugin.broker.v1.ConnInfo
+	1, // [1:2] is the sub-list for method output_type
+	0, // [0:1] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_broker_proto_init() }
+func file_broker_proto_init() {
+	if File_broker_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_broker_proto_rawDesc), len(file_broker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_broker_proto_goTypes,
+		DependencyIndexes: file_broker_proto_depIdxs,
+		MessageInfos:      file_broker_proto_msgTypes,
+	}.Build()
+	File_broker_proto = out.File
+	file_broker_proto_goTypes = nil
+	file_broker_proto_depIdxs = nil
+}
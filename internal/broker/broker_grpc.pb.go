@@ -0,0 +1,123 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.31.1
+// source: broker.proto
+
+package broker
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Broker_StartStream_FullMethodName = "/grpcplugin.broker.v1.Broker/StartStream"
+)
+
+// BrokerClient is the client API for Broker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Broker lets the runner and a plugin tell each other where to reach the
+// sub-connections they accept. plugin.StartPlugin registers it for every
+// plugin, the runner opens a single stream once the plugin is ready.
+type BrokerClient interface {
+	StartStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConnInfo, ConnInfo], error)
+}
+
+type brokerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBrokerClient(cc grpc.ClientConnInterface) BrokerClient {
+	return &brokerClient{cc}
+}
+
+func (c *brokerClient) StartStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ConnInfo, ConnInfo], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Broker_ServiceDesc.Streams[0], Broker_StartStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ConnInfo, ConnInfo]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Broker_StartStreamClient = grpc.BidiStreamingClient[ConnInfo, ConnInfo]
+
+// BrokerServer is the server API for Broker service.
+// All implementations must embed UnimplementedBrokerServer
+// for forward compatibility.
+//
+// Broker lets the runner and a plugin tell each other where to reach the
+// sub-connections they accept. plugin.StartPlugin registers it for every
+// plugin, the runner opens a single stream once the plugin is ready.
+type BrokerServer interface {
+	StartStream(grpc.BidiStreamingServer[ConnInfo, ConnInfo]) error
+	mustEmbedUnimplementedBrokerServer()
+}
+
+// UnimplementedBrokerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBrokerServer struct{}
+
+func (UnimplementedBrokerServer) StartStream(grpc.BidiStreamingServer[ConnInfo, ConnInfo]) error {
+	return status.Errorf(codes.Unimplemented, "method StartStream not implemented")
+}
+func (UnimplementedBrokerServer) mustEmbedUnimplementedBrokerServer() {}
+func (UnimplementedBrokerServer) testEmbeddedByValue()                {}
+
+// UnsafeBrokerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BrokerServer will
+// result in compilation errors.
+type UnsafeBrokerServer interface {
+	mustEmbedUnimplementedBrokerServer()
+}
+
+func RegisterBrokerServer(s grpc.ServiceRegistrar, srv BrokerServer) {
+	// If the following call pancis, it indicates UnimplementedBrokerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Broker_ServiceDesc, srv)
+}
+
+func _Broker_StartStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BrokerServer).StartStream(&grpc.GenericServerStream[ConnInfo, ConnInfo]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Broker_StartStreamServer = grpc.BidiStreamingServer[ConnInfo, ConnInfo]
+
+// Broker_ServiceDesc is the grpc.ServiceDesc for Broker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Broker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpcplugin.broker.v1.Broker",
+	HandlerType: (*BrokerServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StartStream",
+			Handler:       _Broker_StartStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "broker.proto",
+}
@@ -0,0 +1,350 @@
+package broker
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newTestGenerator returns a generator issuing certificates from a CA
+// generated for the test, like the runner's default one.
+func newTestGenerator(t *testing.T) *transport.TransportGenerator {
+	t.Helper()
+	generator, err := transport.NewTransportGenerator(&config.TLSConfig{})
+	if err != nil {
+		t.Fatalf("NewTransportGenerator() error = %v", err)
+	}
+	return generator
+}
+
+// issue issues a certificate with generator and fails the test otherwise.
+func issue(t *testing.T, generator *transport.TransportGenerator, subject string, role transport.Role) *transport.KeyAndCert {
+	t.Helper()
+	keyAndCert, err := generator.GenerateKeyAndCert(subject, role)
+	if err != nil {
+		t.Fatalf("GenerateKeyAndCert() error = %v", err)
+	}
+	return keyAndCert
+}
+
+// testPlugin is the broker of a plugin connected to its host broker over a
+// real broker stream, the way the runner connects to the plugins it starts.
+type testPlugin struct {
+	host   *Broker
+	plugin *Broker
+}
+
+func newTestPlugin(t *testing.T, generator *transport.TransportGenerator, name string, ids *atomic.Uint32) *testPlugin {
+	t.Helper()
+	client := issue(t, generator, name+"_client", transport.RoleClient)
+	server := issue(t, generator, name, transport.RoleServer)
+	server.AllowedClients = []string{client.Fingerprint()}
+
+	serverTLS, err := server.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	plugin := NewPluginBroker(grpcServer, server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	clientTLS, err := client.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	clientTLS.ServerName = transport.ServerName
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	host, err := NewHostBroker(context.Background(), name, conn, client, generator.GenerateKeyAndCert, ids)
+	if err != nil {
+		t.Fatalf("NewHostBroker() error = %v", err)
+	}
+	t.Cleanup(func() {
+		host.Close()
+		plugin.Close()
+	})
+	return &testPlugin{host: host, plugin: plugin}
+}
+
+// serveHealth serves the health service on the sub-connection id of b.
+func serveHealth(t *testing.T, b *Broker, id uint32) {
+	t.Helper()
+	err := b.AcceptAndServe(id, func(opts []grpc.ServerOption) *grpc.Server {
+		server := grpc.NewServer(opts...)
+		healthpb.RegisterHealthServer(server, health.NewServer())
+		return server
+	})
+	if err != nil {
+		t.Fatalf("AcceptAndServe(%d) error = %v", id, err)
+	}
+}
+
+// checkHealth dials the sub-connection id of b and calls the health service
+// on it.
+func checkHealth(t *testing.T, b *Broker, id uint32) {
+	t.Helper()
+	conn, err := b.Dial(id)
+	if err != nil {
+		t.Fatalf("Dial(%d) error = %v", id, err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check on sub-connection %d error = %v", id, err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check on sub-connection %d = %s, want %s", id, resp.GetStatus(), healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// checkEcho accepts the sub-connection id on acceptor, dials it from dialer
+// and checks that a message makes the round trip.
+func checkEcho(t *testing.T, acceptor, dialer *Broker, id uint32) {
+	t.Helper()
+	listener, err := acceptor.Accept(id)
+	if err != nil {
+		t.Fatalf("Accept(%d) error = %v", id, err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := dialer.DialConn(id)
+	if err != nil {
+		t.Fatalf("DialConn(%d) error = %v", id, err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("echoed %q, want %q", got, "ping")
+	}
+}
+
+func TestBrokerLoopback(t *testing.T) {
+	generator := newTestGenerator(t)
+	var ids atomic.Uint32
+	p := newTestPlugin(t, generator, "plugin", &ids)
+
+	tests := []struct {
+		name     string
+		acceptor *Broker
+		dialer   *Broker
+	}{
+		{name: "plugin accepts, runner dials", acceptor: p.plugin, dialer: p.host},
+		{name: "runner accepts, plugin dials", acceptor: p.host, dialer: p.plugin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+", grpc", func(t *testing.T) {
+			id := tt.acceptor.NextID()
+			serveHealth(t, tt.acceptor, id)
+			checkHealth(t, tt.dialer, id)
+		})
+		t.Run(tt.name+", conn", func(t *testing.T) {
+			checkEcho(t, tt.acceptor, tt.dialer, tt.acceptor.NextID())
+		})
+	}
+}
+
+func TestBrokerForward(t *testing.T) {
+	generator := newTestGenerator(t)
+	var ids atomic.Uint32
+	a := newTestPlugin(t, generator, "a", &ids)
+	b := newTestPlugin(t, generator, "b", &ids)
+
+	id := a.plugin.NextID()
+	serveHealth(t, a.plugin, id)
+
+	if _, err := a.plugin.Forward(id, b.plugin); err == nil {
+		t.Error("Forward() on a plugin broker succeeded, want an error")
+	}
+
+	forwardedID, err := a.host.Forward(id, b.host)
+	if err != nil {
+		t.Fatalf("Forward(%d) error = %v", id, err)
+	}
+	checkHealth(t, b.plugin, forwardedID)
+}
+
+func TestBrokerAnnouncementTimeout(t *testing.T) {
+	generator := newTestGenerator(t)
+	var ids atomic.Uint32
+	a := newTestPlugin(t, generator, "a", &ids)
+	b := newTestPlugin(t, generator, "b", &ids)
+
+	// Nobody accepts these ids. The waits run at once, so the test takes
+	// announceTimeout only once.
+	returned := startWaiters(map[string]func() error{
+		"Dial": func() error {
+			_, err := a.host.Dial(1000)
+			return err
+		},
+		"DialConn": func() error {
+			_, err := a.plugin.DialConn(1001)
+			return err
+		},
+		"Forward": func() error {
+			_, err := a.host.Forward(1002, b.host)
+			return err
+		},
+	})
+	start := time.Now()
+	for name, errs := range returned {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("%s of a sub-connection nobody accepts succeeded", name)
+			}
+			if elapsed := time.Since(start); elapsed < announceTimeout-100*time.Millisecond {
+				t.Errorf("%s gave up after %v, want %v", name, elapsed, announceTimeout)
+			}
+		case <-time.After(2 * announceTimeout):
+			t.Errorf("%s did not time out", name)
+		}
+	}
+}
+
+// startWaiters runs every waiter in its own goroutine and returns the
+// channels their errors are delivered on, keyed like waiters.
+func startWaiters(waiters map[string]func() error) map[string]chan error {
+	returned := make(map[string]chan error, len(waiters))
+	for name, wait := range waiters {
+		errs := make(chan error, 1)
+		returned[name] = errs
+		go func() {
+			errs <- wait()
+		}()
+	}
+	return returned
+}
+
+func TestBrokerCloseUnblocksWaiters(t *testing.T) {
+	generator := newTestGenerator(t)
+	var ids atomic.Uint32
+	a := newTestPlugin(t, generator, "a", &ids)
+	b := newTestPlugin(t, generator, "b", &ids)
+
+	returned := startWaiters(map[string]func() error{
+		"Dial": func() error {
+			_, err := a.host.Dial(1000)
+			return err
+		},
+		"DialConn": func() error {
+			_, err := a.host.DialConn(1001)
+			return err
+		},
+		"Forward": func() error {
+			_, err := a.host.Forward(1002, b.host)
+			return err
+		},
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	a.host.Close()
+	for name, errs := range returned {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Errorf("%s on a closed broker succeeded", name)
+			}
+		case <-time.After(announceTimeout / 2):
+			t.Errorf("%s was not unblocked by Close", name)
+		}
+	}
+
+	if _, err := a.host.Accept(a.host.NextID()); err == nil {
+		t.Error("Accept() on a closed broker succeeded")
+	}
+	// Closing the runner's side ends the stream and with it the plugin's
+	// side.
+	select {
+	case <-a.plugin.Done():
+	case <-time.After(announceTimeout):
+		t.Error("plugin broker was not closed with the stream")
+	}
+}
+
+func TestVerifyClient(t *testing.T) {
+	generator := newTestGenerator(t)
+	runner := issue(t, generator, "plugin_client", transport.RoleClient)
+	forwarded := issue(t, generator, "broker_client", transport.RoleClient)
+	other := issue(t, generator, "other_host", transport.RoleClient)
+	server := issue(t, generator, "plugin", transport.RoleServer)
+	server.AllowedClients = []string{runner.Fingerprint()}
+
+	b := newBroker(slog.Default())
+	b.keyAndCert = server
+	b.allowClient(2, forwarded.Fingerprint())
+
+	verify := func(id uint32, client *transport.KeyAndCert) error {
+		return b.verifyClient(id)(nil, [][]*x509.Certificate{{client.Cert, client.CACert}})
+	}
+
+	if err := verify(2, runner); err != nil {
+		t.Errorf("runner certificate refused: %v", err)
+	}
+	if err := verify(2, forwarded); err != nil {
+		t.Errorf("allowed certificate refused: %v", err)
+	}
+
+	// A certificate allowed while the handshake waits is accepted.
+	verified := make(chan error, 1)
+	go func() {
+		verified <- verify(4, forwarded)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.allowClient(4, forwarded.Fingerprint())
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Errorf("certificate allowed during the handshake refused: %v", err)
+		}
+	case <-time.After(announceTimeout):
+		t.Error("certificate allowed during the handshake was not accepted")
+	}
+
+	// Closing the broker ends the wait for certificates that are never
+	// allowed.
+	b.Close()
+	if err := verify(3, forwarded); err == nil {
+		t.Error("certificate allowed for another id accepted")
+	}
+	if err := verify(2, other); err == nil {
+		t.Error("certificate that was never allowed accepted")
+	}
+}
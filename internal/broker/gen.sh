@@ -0,0 +1,6 @@
+#!/bin/bash
+set -euo pipefail
+
+ protoc --go_out=. --go_opt=paths=source_relative \
+     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+     broker.proto
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HandshakeVersion is the version of the handshake line written by plugins.
+// Version 1 lacks the protocol version.
+const HandshakeVersion = 2
+
+// Handshake is what a plugin reports to the runner once it listens: where
+// its gRPC server can be reached and which certificate it serves.
+//
+// It is sent as a single line of the form
+//
+//	<version>|<protocol version>|<network>|<address>|<cert fingerprint>
+type Handshake struct {
+	Version int
+	// ProtocolVersion is the version of the plugin protocol the plugin
+	// speaks, 0 if it does not declare one.
+	ProtocolVersion int
+	// Network is "tcp" or "unix".
+	Network string
+	// Address is a host:port for "tcp" and a socket path for "unix".
+	Address string
+	// CertFingerprint is the fingerprint of the server certificate, see
+	// KeyAndCert.Fingerprint.
+	CertFingerprint string
+}
+
+// Marshal returns the handshake line, including the trailing newline.
+func (h *Handshake) Marshal() []byte {
+	return []byte(fmt.Sprintf("%d|%d|%s|%s|%s\n", h.Version, h.ProtocolVersion, h.Network, h.Address, h.CertFingerprint))
+}
+
+// ParseHandshake parses a handshake line written by Marshal.
+func ParseHandshake(line []byte) (*Handshake, error) {
+	text := strings.TrimSuffix(string(line), "\n")
+
+	// The address is taken as everything between the network and the
+	// fingerprint, so socket paths may contain the separator.
+	version, rest, ok := strings.Cut(text, "|")
+	if !ok {
+		return nil, errors.Errorf("malformed handshake %q", text)
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return nil, errors.Errorf("malformed handshake version %q", version)
+	}
+	if v != 1 && v != HandshakeVersion {
+		return nil, errors.Errorf("unsupported handshake version %d, expected %d", v, HandshakeVersion)
+	}
+
+	var protocolVersion int
+	if v >= 2 {
+		var protocol string
+		protocol, rest, ok = strings.Cut(rest, "|")
+		if !ok {
+			return nil, errors.Errorf("malformed handshake %q", text)
+		}
+		protocolVersion, err = strconv.Atoi(protocol)
+		if err != nil || protocolVersion < 0 {
+			return nil, errors.Errorf("malformed handshake protocol version %q", protocol)
+		}
+	}
+
+	network, rest, ok := strings.Cut(rest, "|")
+	if !ok {
+		return nil, errors.Errorf("malformed handshake %q", text)
+	}
+	separator := strings.LastIndex(rest, "|")
+	if separator < 0 {
+		return nil, errors.Errorf("malformed handshake %q", text)
+	}
+
+	h := &Handshake{
+		Version:         v,
+		ProtocolVersion: protocolVersion,
+		Network:         network,
+		Address:         rest[:separator],
+		CertFingerprint: rest[separator+1:],
+	}
+	switch h.Network {
+	case "tcp", "unix":
+	default:
+		return nil, errors.Errorf("unsupported handshake network %q", h.Network)
+	}
+	if h.Address == "" {
+		return nil, errors.New("handshake address cannot be empty")
+	}
+	return h, nil
+}
+
+// Fingerprint returns the hex encoded SHA-256 digest of the certificate.
+func (k *KeyAndCert) Fingerprint() string {
+	return fingerprint(k.Cert.Raw)
+}
+
+func fingerprint(rawCert []byte) string {
+	digest := sha256.Sum256(rawCert)
+	return hex.EncodeToString(digest[:])
+}
+
+// VerifyFingerprint returns a tls.Config VerifyPeerCertificate callback that
+// only accepts peers whose verified certificate has one of fingerprints.
+func VerifyFingerprint(fingerprints ...string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errors.New("peer certificate was not verified")
+		}
+		if !slices.Contains(fingerprints, fingerprint(verifiedChains[0][0].Raw)) {
+			return errors.New("peer certificate is not allowed on this connection")
+		}
+		return nil
+	}
+}
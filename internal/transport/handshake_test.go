@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		handshake Handshake
+	}{
+		{
+			name:      "tcp",
+			handshake: Handshake{Version: HandshakeVersion, ProtocolVersion: 3, Network: "tcp", Address: "127.0.0.1:41234", CertFingerprint: "ab12"},
+		},
+		{
+			name:      "unix",
+			handshake: Handshake{Version: HandshakeVersion, ProtocolVersion: 1, Network: "unix", Address: "/tmp/grpc-plugin-1/greeter.sock", CertFingerprint: "ab12"},
+		},
+		{
+			name:      "no protocol version",
+			handshake: Handshake{Version: HandshakeVersion, Network: "tcp", Address: "[::1]:41234", CertFingerprint: "ab12"},
+		},
+		{
+			name:      "socket path with separator",
+			handshake: Handshake{Version: HandshakeVersion, Network: "unix", Address: "/tmp/a|b/greeter.sock", CertFingerprint: "ab12"},
+		},
+		{
+			name:      "no fingerprint",
+			handshake: Handshake{Version: HandshakeVersion, Network: "tcp", Address: "127.0.0.1:41234"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := tt.handshake.Marshal()
+			if line[len(line)-1] != '\n' {
+				t.Errorf("Marshal() = %q, want a trailing newline", line)
+			}
+			got, err := ParseHandshake(line)
+			if err != nil {
+				t.Fatalf("ParseHandshake(%q) error = %v", line, err)
+			}
+			if !reflect.DeepEqual(*got, tt.handshake) {
+				t.Errorf("ParseHandshake(%q) = %+v, want %+v", line, *got, tt.handshake)
+			}
+		})
+	}
+}
+
+func TestParseHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *Handshake
+		wantErr bool
+	}{
+		{
+			name: "version 2",
+			line: "2|3|tcp|127.0.0.1:41234|ab12\n",
+			want: &Handshake{Version: 2, ProtocolVersion: 3, Network: "tcp", Address: "127.0.0.1:41234", CertFingerprint: "ab12"},
+		},
+		{
+			name: "version 1",
+			line: "1|tcp|127.0.0.1:41234|ab12\n",
+			want: &Handshake{Version: 1, Network: "tcp", Address: "127.0.0.1:41234", CertFingerprint: "ab12"},
+		},
+		{
+			name: "without newline",
+			line: "2|0|unix|/tmp/greeter.sock|ab12",
+			want: &Handshake{Version: 2, Network: "unix", Address: "/tmp/greeter.sock", CertFingerprint: "ab12"},
+		},
+		{
+			name:    "empty",
+			line:    "",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			line:    "3|0|tcp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed version",
+			line:    "v2|0|tcp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "malformed protocol version",
+			line:    "2|x|tcp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative protocol version",
+			line:    "2|-1|tcp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "version 2 without protocol version",
+			line:    "2|tcp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported network",
+			line:    "2|0|udp|127.0.0.1:41234|ab12\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing fingerprint separator",
+			line:    "2|0|tcp|127.0.0.1:41234\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty address",
+			line:    "2|0|tcp||ab12\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHandshake([]byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHandshake(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseHandshake(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
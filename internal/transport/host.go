@@ -0,0 +1,15 @@
+package transport
+
+import (
+	"encoding/json"
+)
+
+// HostInfo tells a plugin how to reach the services its host offers. The
+// runner sends it over an inherited pipe.
+type HostInfo struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// KeyAndCert is the serialized client certificate the plugin presents
+	// to the host, see KeyAndCert.Serialize.
+	KeyAndCert json.RawMessage `json:"key_and_cert"`
+}
@@ -8,9 +8,11 @@ import (
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"log/slog"
 	"math/big"
 	"net"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
@@ -80,8 +82,17 @@ type KeyAndCert struct {
 	CACertBytes []byte
 	Cert        *x509.Certificate
 	CertBytes   []byte
+	// AllowedClients, if not empty, are the fingerprints of the only client
+	// certificates a server using this pair accepts. Otherwise any client
+	// certificate issued by the CA is accepted.
+	AllowedClients []string
 }
 
+// ServerName is the name clients verify server certificates against. Servers
+// listen on loopback addresses, but certificates only have to be valid for
+// localhost, see LoadKeyAndCert.
+const ServerName = "localhost"
+
 func (k *KeyAndCert) GetTLSConfig() (*tls.Config, error) {
 	logger := slog.Default().With("component", "transport", "cn", k.CN)
 	logger.Debug("creating TLS config")
@@ -103,6 +114,9 @@ func (k *KeyAndCert) GetTLSConfig() (*tls.Config, error) {
 		RootCAs:   certPool,
 		ClientCAs: certPool,
 	}
+	if len(k.AllowedClients) > 0 {
+		tlsConfig.VerifyPeerCertificate = VerifyFingerprint(k.AllowedClients...)
+	}
 
 	logger.Debug("TLS config created successfully")
 	return tlsConfig, nil
@@ -113,6 +127,9 @@ type KeyAndCertSerialized struct {
 	CACertBytes string `json:"ca_cert_bytes"`
 	PrivateKey  string `json:"private_key_pkcs1"`
 	CN          string `json:"cn"`
+	// AllowedClients is omitted when empty, plugins built against older
+	// versions of this library ignore it.
+	AllowedClients []string `json:"allowed_clients,omitempty"`
 }
 
 func (k *KeyAndCert) Serialize() ([]byte, error) {
@@ -124,6 +141,8 @@ func (k *KeyAndCert) Serialize() ([]byte, error) {
 		CACertBytes: base64.StdEncoding.EncodeToString(k.CACert.Raw),
 		PrivateKey:  base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(k.Key)),
 		CN:          k.CN,
+
+		AllowedClients: k.AllowedClients,
 	}
 
 	jsonBytes, err := json.Marshal(toSerialize)
@@ -171,6 +190,7 @@ func DeserializeKeyAndCert(data []byte) (*KeyAndCert, error) {
 	}
 
 	k.CN = toDeserialize.CN
+	k.AllowedClients = toDeserialize.AllowedClients
 
 	cert, err := x509.ParseCertificate(k.CertBytes)
 	if err != nil {
@@ -205,6 +225,10 @@ func GenerateKeyAndCertFromCA(ca *PrivateCA, subject string, role Role) (*KeyAnd
 		logger.Error("CA cannot be nil")
 		return nil, errors.New("CA cannot be nil")
 	}
+	if ca.PrivateKey == nil {
+		logger.Error("CA has no private key")
+		return nil, errors.New("CA has no private key and cannot issue certificates")
+	}
 
 	serverKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
 	if err != nil {
@@ -228,6 +252,12 @@ func GenerateKeyAndCertFromCA(ca *PrivateCA, subject string, role Role) (*KeyAnd
 		return nil, errors.Wrapf(err, "failed to generate serial number for %s", subject)
 	}
 
+	// Valid for 1 year, but never beyond the issuing CA
+	notAfter := time.Now().AddDate(1, 0, 0)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+
 	template := &x509.Certificate{
 		SerialNumber: sn,
 		Subject: pkix.Name{
@@ -235,7 +265,7 @@ func GenerateKeyAndCertFromCA(ca *PrivateCA, subject string, role Role) (*KeyAnd
 			Organization: []string{"GRPC_Plugins"},
 		},
 		NotBefore:   time.Now().Add(-time.Second),
-		NotAfter:    time.Now().AddDate(1, 0, 0), // Valid for 1 year
+		NotAfter:    notAfter,
 		KeyUsage:    x509.KeyUsageDigitalSignature,
 		ExtKeyUsage: usage,
 		// TODO: Is this a security concern?
@@ -266,3 +296,158 @@ func GenerateKeyAndCertFromCA(ca *PrivateCA, subject string, role Role) (*KeyAnd
 		CertBytes:   certBytes,
 	}, nil
 }
+
+// LoadPrivateCA reads a PEM encoded CA certificate and, if keyPath is not
+// empty, its RSA private key. A CA loaded without a key can only be used to
+// verify pre-issued certificates.
+func LoadPrivateCA(certPath, keyPath string) (*PrivateCA, error) {
+	logger := slog.Default().With("component", "transport", "cert_path", certPath)
+	logger.Debug("loading private CA")
+
+	cert, err := readCertificateFile(certPath)
+	if err != nil {
+		logger.Error("failed to read CA certificate", "error", err)
+		return nil, errors.Wrap(err, "failed to read CA certificate")
+	}
+	if !cert.IsCA {
+		logger.Error("certificate is not a CA")
+		return nil, errors.Errorf("certificate in %s is not a CA certificate", certPath)
+	}
+
+	ca := &PrivateCA{
+		Cert:      cert,
+		CertBytes: cert.Raw,
+	}
+
+	if keyPath != "" {
+		ca.PrivateKey, err = readPrivateKeyFile(keyPath)
+		if err != nil {
+			logger.Error("failed to read CA private key", "error", err)
+			return nil, errors.Wrap(err, "failed to read CA private key")
+		}
+		if !ca.PrivateKey.PublicKey.Equal(cert.PublicKey) {
+			logger.Error("CA private key does not match certificate")
+			return nil, errors.Errorf("private key in %s does not match CA certificate in %s", keyPath, certPath)
+		}
+	}
+
+	logger.Debug("private CA loaded successfully", "subject", cert.Subject.String())
+	return ca, nil
+}
+
+// LoadKeyAndCert reads a pre-issued PEM encoded certificate and RSA private
+// key and checks that the certificate was issued by ca for role.
+func LoadKeyAndCert(ca *PrivateCA, certPath, keyPath string, role Role) (*KeyAndCert, error) {
+	logger := slog.Default().With("component", "transport", "cert_path", certPath, "role", role)
+	logger.Debug("loading key and cert")
+
+	if ca == nil {
+		logger.Error("CA cannot be nil")
+		return nil, errors.New("CA cannot be nil")
+	}
+
+	var usage x509.ExtKeyUsage
+	switch role {
+	case RoleServer:
+		usage = x509.ExtKeyUsageServerAuth
+	case RoleClient:
+		usage = x509.ExtKeyUsageClientAuth
+	default:
+		return nil, errors.Errorf("invalid role %q, must be 'server' or 'client'", role)
+	}
+
+	cert, err := readCertificateFile(certPath)
+	if err != nil {
+		logger.Error("failed to read certificate", "error", err)
+		return nil, errors.Wrap(err, "failed to read certificate")
+	}
+
+	key, err := readPrivateKeyFile(keyPath)
+	if err != nil {
+		logger.Error("failed to read private key", "error", err)
+		return nil, errors.Wrap(err, "failed to read private key")
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		logger.Error("private key does not match certificate")
+		return nil, errors.Errorf("private key in %s does not match certificate in %s", keyPath, certPath)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+		logger.Error("certificate does not chain to CA", "error", err)
+		return nil, errors.Wrapf(err, "certificate in %s is not valid for role %s under the configured CA", certPath, role)
+	}
+	if role == RoleServer {
+		// Clients verify server certificates against ServerName whatever
+		// address they connect to.
+		if err := cert.VerifyHostname(ServerName); err != nil {
+			logger.Error("server certificate is not valid for localhost", "error", err)
+			return nil, errors.Wrapf(err, "server certificate in %s must be valid for localhost", certPath)
+		}
+	}
+
+	logger.Debug("key and cert loaded successfully")
+	return &KeyAndCert{
+		CN:          cert.Subject.CommonName,
+		Key:         key,
+		CACert:      ca.Cert,
+		CACertBytes: ca.CertBytes,
+		Cert:        cert,
+		CertBytes:   cert.Raw,
+	}, nil
+}
+
+func readCertificateFile(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("no PEM encoded certificate found in %s", path)
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate in %s", path)
+		}
+		return cert, nil
+	}
+}
+
+func readPrivateKeyFile(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("no PEM encoded private key found in %s", path)
+		}
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse PKCS1 private key in %s", path)
+			}
+			return key, nil
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse PKCS8 private key in %s", path)
+			}
+			key, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				return nil, errors.Errorf("private key in %s is %T, only RSA keys are supported", path, parsed)
+			}
+			return key, nil
+		}
+	}
+}
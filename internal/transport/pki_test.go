@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// issue issues a certificate from ca and fails the test otherwise.
+func issue(t *testing.T, ca *PrivateCA, subject string, role Role) *KeyAndCert {
+	t.Helper()
+	keyAndCert, err := GenerateKeyAndCertFromCA(ca, subject, role)
+	if err != nil {
+		t.Fatalf("GenerateKeyAndCertFromCA() error = %v", err)
+	}
+	return keyAndCert
+}
+
+// handshake runs a TLS handshake between a server and a client using the
+// given pairs and returns the server's error.
+func handshake(t *testing.T, server, client *KeyAndCert) error {
+	t.Helper()
+	serverConfig, err := server.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	clientConfig, err := client.GetTLSConfig()
+	if err != nil {
+		t.Fatalf("GetTLSConfig() error = %v", err)
+	}
+	clientConfig.ServerName = ServerName
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		tlsClient := tls.Client(clientConn, clientConfig)
+		if tlsClient.Handshake() == nil {
+			// TLS 1.3 clients only learn about a rejected certificate on
+			// their first read.
+			tlsClient.Read(make([]byte, 1))
+		}
+		clientConn.Close()
+	}()
+	tlsServer := tls.Server(serverConn, serverConfig)
+	err = tlsServer.Handshake()
+	if err == nil {
+		tlsServer.Write([]byte{0})
+	}
+	serverConn.Close()
+	<-clientDone
+	return err
+}
+
+func TestGetTLSConfigAllowedClients(t *testing.T) {
+	ca, err := GeneratePrivateCA()
+	if err != nil {
+		t.Fatalf("GeneratePrivateCA() error = %v", err)
+	}
+	runner := issue(t, ca, "plugin_client", RoleClient)
+	other := issue(t, ca, "other_host", RoleClient)
+
+	tests := []struct {
+		name           string
+		allowedClients []string
+		client         *KeyAndCert
+		wantErr        bool
+	}{
+		{name: "any client of the CA", client: other},
+		{name: "allowed client", allowedClients: []string{runner.Fingerprint()}, client: runner},
+		{name: "other client of the CA", allowedClients: []string{runner.Fingerprint()}, client: other, wantErr: true},
+		{name: "one of several allowed clients", allowedClients: []string{runner.Fingerprint(), other.Fingerprint()}, client: other},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := issue(t, ca, "plugin", RoleServer)
+			server.AllowedClients = tt.allowedClients
+			if err := handshake(t, server, tt.client); (err != nil) != tt.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSerializeKeepsAllowedClients(t *testing.T) {
+	ca, err := GeneratePrivateCA()
+	if err != nil {
+		t.Fatalf("GeneratePrivateCA() error = %v", err)
+	}
+	keyAndCert := issue(t, ca, "plugin", RoleServer)
+
+	for _, allowedClients := range [][]string{nil, {"ab12", "cd34"}} {
+		keyAndCert.AllowedClients = allowedClients
+		serialized, err := keyAndCert.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() error = %v", err)
+		}
+		got, err := DeserializeKeyAndCert(serialized)
+		if err != nil {
+			t.Fatalf("DeserializeKeyAndCert() error = %v", err)
+		}
+		if !reflect.DeepEqual(got.AllowedClients, allowedClients) {
+			t.Errorf("AllowedClients = %v, want %v", got.AllowedClients, allowedClients)
+		}
+		if got.Fingerprint() != keyAndCert.Fingerprint() {
+			t.Errorf("Fingerprint() = %s, want %s", got.Fingerprint(), keyAndCert.Fingerprint())
+		}
+	}
+}
+
+func TestLoadKeyAndCertLocalhostOnly(t *testing.T) {
+	ca, err := GeneratePrivateCA()
+	if err != nil {
+		t.Fatalf("GeneratePrivateCA() error = %v", err)
+	}
+	generated := issue(t, ca, "plugin", RoleServer)
+	client := issue(t, ca, "plugin_client", RoleClient)
+
+	// Pre-issued certificates often only name localhost, although plugins
+	// listen on 127.0.0.1.
+	template := *generated.Cert
+	template.IPAddresses = nil
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, ca.Cert, &generated.Key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(generated.Key)}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := LoadKeyAndCert(ca, certPath, keyPath, RoleServer)
+	if err != nil {
+		t.Fatalf("LoadKeyAndCert() error = %v", err)
+	}
+	if err := handshake(t, server, client); err != nil {
+		t.Errorf("handshake error = %v", err)
+	}
+}
@@ -21,17 +21,21 @@ func NewTransportGenerator(cfg *config.TLSConfig) (*TransportGenerator, error) {
 	}
 
 	if cfg.UseCustomTLS {
-		logger.Error("custom TLS is not supported")
-		return nil, errors.New("custom TLS is not supported yet")
+		ca, err := LoadPrivateCA(cfg.CACertPath, cfg.CAKeyPath)
+		if err != nil {
+			logger.Error("failed to load custom CA", "error", err)
+			return nil, errors.Wrap(err, "failed to load custom CA")
+		}
+		t.ca = ca
+	} else {
+		ca, err := GeneratePrivateCA()
+		if err != nil {
+			logger.Error("failed to generate private CA", "error", err)
+			return nil, errors.Wrap(err, "failed to generate private CA")
+		}
+		t.ca = ca
 	}
 
-	ca, err := GeneratePrivateCA()
-	if err != nil {
-		logger.Error("failed to generate private CA", "error", err)
-		return nil, errors.Wrap(err, "failed to generate private CA")
-	}
-	t.ca = ca
-
 	logger.Debug("transport generator created successfully")
 	return t, nil
 }
@@ -54,3 +58,38 @@ func (t *TransportGenerator) GenerateKeyAndCert(subject string, role Role) (*Key
 	logger.Debug("key and cert generated successfully")
 	return keyAndCert, nil
 }
+
+// KeyAndCertForPlugin returns the key and certificate plugin uses for role,
+// loading a pre-issued pair from the manifest if there is one and issuing a
+// new one from the CA otherwise.
+func (t *TransportGenerator) KeyAndCertForPlugin(plugin config.ManifestPlugin, role Role) (*KeyAndCert, error) {
+	logger := slog.Default().With("component", "transport_generator", "plugin", plugin.GetName(), "role", role)
+
+	var certPath, keyPath, subject string
+	switch role {
+	case RoleServer:
+		if plugin.TLS.HasServerCert() {
+			certPath, keyPath = plugin.TLS.ServerCertPath, plugin.TLS.ServerKeyPath
+		}
+		subject = plugin.GetName()
+	case RoleClient:
+		if plugin.TLS.HasClientCert() {
+			certPath, keyPath = plugin.TLS.ClientCertPath, plugin.TLS.ClientKeyPath
+		}
+		subject = plugin.GetName() + "_client"
+	default:
+		return nil, errors.Errorf("invalid role: %s, must be %s or %s", role, RoleServer, RoleClient)
+	}
+
+	if certPath == "" {
+		return t.GenerateKeyAndCert(subject, role)
+	}
+
+	logger.Debug("using pre-issued key and cert", "cert_path", certPath)
+	keyAndCert, err := LoadKeyAndCert(t.ca, certPath, keyPath, role)
+	if err != nil {
+		logger.Error("failed to load pre-issued key and cert", "error", err)
+		return nil, errors.Wrapf(err, "failed to load %s key and cert for plugin %s", role, plugin.GetName())
+	}
+	return keyAndCert, nil
+}
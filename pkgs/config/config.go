@@ -2,8 +2,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
@@ -71,4 +75,108 @@ type Config[T any] struct {
 	Manifest        *Manifest
 	LoggerOptions   *LoggerOptions
 	PluginGenerator func(conn grpc.ClientConnInterface) T
+	// ProtocolVersion is the version of the plugin protocol, e.g. of the
+	// shared proto, the host speaks. Plugins declaring another version are
+	// refused. Zero disables the check unless VersionedPluginGenerators is
+	// set.
+	ProtocolVersion int
+	// MinProtocolVersion makes plugins declaring any version from
+	// MinProtocolVersion up to ProtocolVersion acceptable.
+	MinProtocolVersion int
+	// VersionedPluginGenerators adds protocol versions the host supports
+	// with a client of their own. A plugin declaring one of them gets its
+	// client from this generator instead of PluginGenerator.
+	VersionedPluginGenerators map[int]func(conn grpc.ClientConnInterface) T
+	// HostServices registers the gRPC services plugins may call back into.
+	// The host serves them over mTLS, every plugin instance with a client
+	// certificate of its own. Nil offers no services.
+	HostServices func(registrar grpc.ServiceRegistrar)
+}
+
+// negotiates reports whether plugins have to declare a supported protocol
+// version.
+func (c *Config[T]) negotiates() bool {
+	return c.ProtocolVersion != 0 || len(c.VersionedPluginGenerators) > 0
+}
+
+// ValidateProtocol checks the generator and protocol version settings.
+func (c *Config[T]) ValidateProtocol() error {
+	if c.PluginGenerator == nil {
+		return errors.New("plugin generator cannot be nil")
+	}
+	if c.ProtocolVersion < 0 || c.MinProtocolVersion < 0 {
+		return errors.New("protocol versions cannot be negative")
+	}
+	if c.MinProtocolVersion != 0 && c.ProtocolVersion == 0 {
+		return errors.New("min protocol version requires a protocol version")
+	}
+	if c.MinProtocolVersion > c.ProtocolVersion {
+		return errors.Errorf("min protocol version %d is greater than protocol version %d", c.MinProtocolVersion, c.ProtocolVersion)
+	}
+	for version, generator := range c.VersionedPluginGenerators {
+		if version <= 0 {
+			return errors.Errorf("versioned plugin generator for invalid protocol version %d", version)
+		}
+		if generator == nil {
+			return errors.Errorf("versioned plugin generator for protocol version %d cannot be nil", version)
+		}
+	}
+	return nil
+}
+
+// SupportedProtocolVersions describes the protocol versions the host
+// accepts, e.g. "1-3, 5".
+func (c *Config[T]) SupportedProtocolVersions() string {
+	if !c.negotiates() {
+		return "any"
+	}
+
+	var supported []string
+	if c.ProtocolVersion != 0 {
+		if c.MinProtocolVersion != 0 && c.MinProtocolVersion < c.ProtocolVersion {
+			supported = append(supported, fmt.Sprintf("%d-%d", c.MinProtocolVersion, c.ProtocolVersion))
+		} else {
+			supported = append(supported, strconv.Itoa(c.ProtocolVersion))
+		}
+	}
+	versions := make([]int, 0, len(c.VersionedPluginGenerators))
+	for version := range c.VersionedPluginGenerators {
+		if !c.inRange(version) {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+	for _, version := range versions {
+		supported = append(supported, strconv.Itoa(version))
+	}
+	return strings.Join(supported, ", ")
+}
+
+// inRange reports whether version is in the range given by
+// MinProtocolVersion and ProtocolVersion.
+func (c *Config[T]) inRange(version int) bool {
+	if c.ProtocolVersion == 0 {
+		return false
+	}
+	lowest := c.MinProtocolVersion
+	if lowest == 0 {
+		lowest = c.ProtocolVersion
+	}
+	return version >= lowest && version <= c.ProtocolVersion
+}
+
+// NegotiateProtocol returns the generator for clients of a plugin declaring
+// the given protocol version, 0 for none, or an error if the host does not
+// support that version.
+func (c *Config[T]) NegotiateProtocol(version int) (func(conn grpc.ClientConnInterface) T, error) {
+	if generator, ok := c.VersionedPluginGenerators[version]; ok {
+		return generator, nil
+	}
+	if !c.negotiates() || c.inRange(version) {
+		return c.PluginGenerator, nil
+	}
+	if version == 0 {
+		return nil, errors.Errorf("plugin does not declare a protocol version, the host requires %s", c.SupportedProtocolVersions())
+	}
+	return nil, errors.Errorf("plugin speaks protocol version %d, the host supports %s", version, c.SupportedProtocolVersions())
 }
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// testGenerator returns a plugin generator whose clients are name.
+func testGenerator(name string) func(conn grpc.ClientConnInterface) string {
+	return func(conn grpc.ClientConnInterface) string { return name }
+}
+
+func TestNegotiateProtocol(t *testing.T) {
+	tests := []struct {
+		name               string
+		protocolVersion    int
+		minProtocolVersion int
+		versioned          []int
+		version            int
+		// want is the name of the negotiated generator, empty if
+		// negotiation fails.
+		want string
+	}{
+		{name: "no versions accept a plugin without one", version: 0, want: "current"},
+		{name: "no versions accept any version", version: 7, want: "current"},
+		{name: "exact version", protocolVersion: 2, version: 2, want: "current"},
+		{name: "older version", protocolVersion: 2, version: 1},
+		{name: "newer version", protocolVersion: 2, version: 3},
+		{name: "plugin without a version", protocolVersion: 2, version: 0},
+		{name: "lowest version of range", protocolVersion: 3, minProtocolVersion: 1, version: 1, want: "current"},
+		{name: "below range", protocolVersion: 3, minProtocolVersion: 2, version: 1},
+		{name: "versioned generator", protocolVersion: 3, versioned: []int{1}, version: 1, want: "v1"},
+		{name: "versioned generator overrides range", protocolVersion: 3, minProtocolVersion: 1, versioned: []int{2}, version: 2, want: "v2"},
+		{name: "versioned generators only", versioned: []int{1}, version: 1, want: "v1"},
+		{name: "versioned generators only refuse others", versioned: []int{1}, version: 2},
+		{name: "versioned generators only refuse no version", versioned: []int{1}, version: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config[string]{
+				PluginGenerator:    testGenerator("current"),
+				ProtocolVersion:    tt.protocolVersion,
+				MinProtocolVersion: tt.minProtocolVersion,
+			}
+			for _, version := range tt.versioned {
+				if cfg.VersionedPluginGenerators == nil {
+					cfg.VersionedPluginGenerators = make(map[int]func(conn grpc.ClientConnInterface) string)
+				}
+				cfg.VersionedPluginGenerators[version] = testGenerator(fmt.Sprintf("v%d", version))
+			}
+
+			generator, err := cfg.NegotiateProtocol(tt.version)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("NegotiateProtocol(%d) accepted the version, want an error", tt.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NegotiateProtocol(%d) error = %v", tt.version, err)
+			}
+			if got := generator(nil); got != tt.want {
+				t.Errorf("NegotiateProtocol(%d) returned generator %q, want %q", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupportedProtocolVersions(t *testing.T) {
+	tests := []struct {
+		name               string
+		protocolVersion    int
+		minProtocolVersion int
+		versioned          []int
+		want               string
+	}{
+		{name: "no versions", want: "any"},
+		{name: "single version", protocolVersion: 2, want: "2"},
+		{name: "range", protocolVersion: 3, minProtocolVersion: 1, want: "1-3"},
+		{name: "range of one", protocolVersion: 3, minProtocolVersion: 3, want: "3"},
+		{name: "versioned generators are sorted", protocolVersion: 3, versioned: []int{5, 1}, want: "3, 1, 5"},
+		{name: "versioned generators in range are not repeated", protocolVersion: 3, minProtocolVersion: 2, versioned: []int{2, 1}, want: "2-3, 1"},
+		{name: "versioned generators only", versioned: []int{4, 2}, want: "2, 4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config[string]{
+				PluginGenerator:    testGenerator("current"),
+				ProtocolVersion:    tt.protocolVersion,
+				MinProtocolVersion: tt.minProtocolVersion,
+			}
+			for _, version := range tt.versioned {
+				if cfg.VersionedPluginGenerators == nil {
+					cfg.VersionedPluginGenerators = make(map[int]func(conn grpc.ClientConnInterface) string)
+				}
+				cfg.VersionedPluginGenerators[version] = testGenerator("versioned")
+			}
+
+			if got := cfg.SupportedProtocolVersions(); got != tt.want {
+				t.Errorf("SupportedProtocolVersions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateProtocol(t *testing.T) {
+	generator := testGenerator("current")
+	tests := []struct {
+		name    string
+		cfg     Config[string]
+		wantErr bool
+	}{
+		{name: "no versions", cfg: Config[string]{PluginGenerator: generator}},
+		{name: "range", cfg: Config[string]{PluginGenerator: generator, ProtocolVersion: 3, MinProtocolVersion: 1}},
+		{name: "no generator", cfg: Config[string]{}, wantErr: true},
+		{name: "negative version", cfg: Config[string]{PluginGenerator: generator, ProtocolVersion: -1}, wantErr: true},
+		{name: "min version without version", cfg: Config[string]{PluginGenerator: generator, MinProtocolVersion: 1}, wantErr: true},
+		{name: "min version above version", cfg: Config[string]{PluginGenerator: generator, ProtocolVersion: 1, MinProtocolVersion: 2}, wantErr: true},
+		{
+			name: "versioned generator for version 0",
+			cfg: Config[string]{
+				PluginGenerator:           generator,
+				VersionedPluginGenerators: map[int]func(conn grpc.ClientConnInterface) string{0: generator},
+			},
+			wantErr: true,
+		},
+		{
+			name: "nil versioned generator",
+			cfg: Config[string]{
+				PluginGenerator:           generator,
+				VersionedPluginGenerators: map[int]func(conn grpc.ClientConnInterface) string{1: nil},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.ValidateProtocol(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateProtocol() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
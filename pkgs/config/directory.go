@@ -0,0 +1,218 @@
+package config
+
+import (
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// descriptorName is the descriptor of a plugin living in a subdirectory
+	// of a "directory" manifest.
+	descriptorName = "plugin.yml"
+	// descriptorSuffix marks the descriptor of a plugin binary placed
+	// directly in a "directory" manifest, e.g. "greeter.plugin.yml" for the
+	// binary "greeter".
+	descriptorSuffix = ".plugin.yml"
+)
+
+// pluginDescriptor is a descriptor found in a "directory" manifest.
+type pluginDescriptor struct {
+	// name is the name of the subdirectory or binary, used as the default
+	// plugin name.
+	name string
+	// path is the path to the descriptor file.
+	path string
+	// loose is set for descriptors of binaries placed directly in the
+	// directory.
+	loose bool
+}
+
+// findDescriptors returns the plugin descriptors in dir whose entry name
+// matches glob, sorted by name. Entries starting with a dot are skipped.
+func findDescriptors(dir string, glob string) ([]pluginDescriptor, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read plugins directory %s", dir)
+	}
+
+	var descriptors []pluginDescriptor
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		var descriptor pluginDescriptor
+		if entry.IsDir() {
+			path := filepath.Join(dir, entry.Name(), descriptorName)
+			if _, err := os.Stat(path); err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, errors.Wrapf(err, "failed to stat plugin descriptor %s", path)
+			}
+			descriptor = pluginDescriptor{name: entry.Name(), path: path}
+		} else {
+			name, ok := strings.CutSuffix(entry.Name(), descriptorSuffix)
+			if !ok || name == "" {
+				continue
+			}
+			descriptor = pluginDescriptor{name: name, path: filepath.Join(dir, entry.Name()), loose: true}
+		}
+
+		if glob != "" {
+			matched, err := filepath.Match(glob, descriptor.name)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid manifest glob %q", glob)
+			}
+			if !matched {
+				continue
+			}
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	sort.Slice(descriptors, func(i, j int) bool {
+		return descriptors[i].name < descriptors[j].name
+	})
+	return descriptors, nil
+}
+
+// load reads the descriptor and returns the plugin it describes. Relative
+// paths in the descriptor are resolved against the descriptor's directory.
+func (d pluginDescriptor) load() (ManifestPlugin, error) {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return ManifestPlugin{}, errors.Wrapf(err, "failed to read plugin descriptor %s", d.path)
+	}
+
+	baseDir := filepath.Dir(d.path)
+	data, err = expandManifest(data, baseDir, os.LookupEnv)
+	if err != nil {
+		return ManifestPlugin{}, errors.Wrapf(err, "failed to expand plugin descriptor %s", d.path)
+	}
+
+	var plugin ManifestPlugin
+	if err := yaml.Unmarshal(data, &plugin); err != nil {
+		return ManifestPlugin{}, errors.Wrapf(err, "failed to unmarshal plugin descriptor %s", d.path)
+	}
+
+	if plugin.Name == "" {
+		plugin.Name = d.name
+	}
+	if d.loose {
+		if plugin.Path == "" {
+			plugin.Path = d.name
+		}
+		if plugin.Kind == "" {
+			plugin.Kind = "exec"
+		}
+	} else if plugin.Path == "" {
+		plugin.Path = "."
+	}
+	// Joining cleans the paths, so ".." is checked on the paths as written.
+	if err := plugin.validateRelativePaths(); err != nil {
+		return ManifestPlugin{}, errors.Wrapf(err, "invalid plugin descriptor %s", d.path)
+	}
+
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+	plugin.Path = resolve(plugin.Path)
+	plugin.Workdir = resolve(plugin.Workdir)
+	if plugin.TLS != nil {
+		plugin.TLS.ServerCertPath = resolve(plugin.TLS.ServerCertPath)
+		plugin.TLS.ServerKeyPath = resolve(plugin.TLS.ServerKeyPath)
+		plugin.TLS.ClientCertPath = resolve(plugin.TLS.ClientCertPath)
+		plugin.TLS.ClientKeyPath = resolve(plugin.TLS.ClientKeyPath)
+	}
+	return plugin, nil
+}
+
+// LoadManifestDirectory discovers the plugins of a "directory" manifest. Every
+// subdirectory holding a plugin.yml and every <binary>.plugin.yml next to a
+// binary becomes one plugin; the manifest's Config, if any, provides the
+// remaining settings.
+func LoadManifestDirectory[T any](cfg *Config[T]) (*ManifestConfig, error) {
+	logger := slog.With("component", "config", "manifest_kind", "directory", "path", cfg.Manifest.Path)
+	logger.Debug("loading manifest from directory")
+
+	if err := cfg.Manifest.Validate(); err != nil {
+		logger.Error("invalid manifest configuration", "error", err)
+		return nil, errors.Wrap(err, "invalid manifest configuration")
+	}
+
+	descriptors, err := findDescriptors(cfg.Manifest.Path, cfg.Manifest.Glob)
+	if err != nil {
+		logger.Error("failed to scan plugins directory", "error", err)
+		return nil, err
+	}
+
+	var pluginConfig ManifestConfig
+	if cfg.Manifest.Config != nil {
+		pluginConfig = *cfg.Manifest.Config
+	}
+	pluginConfig.Plugins = make([]ManifestPlugin, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		plugin, err := descriptor.load()
+		if err != nil {
+			logger.Error("failed to load plugin descriptor", "descriptor", descriptor.path, "error", err)
+			return nil, err
+		}
+		logger.Debug("discovered plugin", "plugin", plugin.Name, "descriptor", descriptor.path)
+		pluginConfig.Plugins = append(pluginConfig.Plugins, plugin)
+	}
+
+	if err := pluginConfig.validate(); err != nil {
+		logger.Error("invalid manifest configuration", "error", err)
+		return nil, errors.Wrap(err, "invalid manifest configuration")
+	}
+
+	logger.Info("manifest directory loaded successfully",
+		"plugin_count", len(pluginConfig.Plugins),
+		"use_custom_tls", pluginConfig.TLS.UseCustomTLS)
+	return &pluginConfig, nil
+}
+
+// Fingerprint returns a digest of the files backing a "file" or "directory"
+// manifest. It changes whenever the manifest file, or a descriptor of a
+// "directory" manifest, is added, removed or edited. Plugin binaries and
+// sources are not part of it, replacing them does not change it.
+func (m *Manifest) Fingerprint() ([]byte, error) {
+	hash := sha256.New()
+	switch m.Kind {
+	case "file":
+		data, err := os.ReadFile(m.Path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read manifest file at %s", m.Path)
+		}
+		hash.Write(data)
+	case "directory":
+		descriptors, err := findDescriptors(m.Path, m.Glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, descriptor := range descriptors {
+			data, err := os.ReadFile(descriptor.path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read plugin descriptor %s", descriptor.path)
+			}
+			hash.Write([]byte(descriptor.path))
+			hash.Write([]byte{0})
+			hash.Write(data)
+			hash.Write([]byte{0})
+		}
+	default:
+		return nil, errors.Errorf("manifests of kind %q have no files", m.Kind)
+	}
+	return hash.Sum(nil), nil
+}
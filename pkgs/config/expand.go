@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fileTag marks a scalar whose value is the path of a file to read.
+const fileTag = "!file"
+
+// expandManifest resolves environment variable and file references in a YAML
+// manifest and returns the resulting YAML document.
+//
+// ${VAR} is replaced by the value of the environment variable VAR, which must
+// be set, and ${VAR:-default} falls back to default if VAR is unset or empty.
+// $${ produces a literal ${. A scalar tagged `!file` is replaced by the
+// contents of the file at its path, without a trailing newline; relative
+// paths are resolved against baseDir. References are only expanded in values,
+// not in keys.
+//
+// Expansion works on the parsed nodes, everything else in the manifest is
+// written back with its original text and style. An expanded value keeps the
+// style it was written in: a plain value is typed as if the expanded text had
+// been written into the manifest, a quoted one stays a string. File contents
+// are always strings.
+func expandManifest(data []byte, baseDir string, lookupEnv func(string) (string, bool)) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, errors.Wrap(err, "failed to parse manifest")
+	}
+	if document.Kind == 0 {
+		return data, nil
+	}
+
+	expander := &manifestExpander{
+		baseDir:   baseDir,
+		lookupEnv: lookupEnv,
+	}
+	if err := expander.expand(&document); err != nil {
+		return nil, err
+	}
+
+	expanded, err := yaml.Marshal(&document)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode expanded manifest")
+	}
+	return expanded, nil
+}
+
+type manifestExpander struct {
+	baseDir   string
+	lookupEnv func(string) (string, bool)
+}
+
+func (e *manifestExpander) expand(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, item := range node.Content {
+			if err := e.expand(item); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := e.expand(node.Content[i+1]); err != nil {
+				return errors.Wrapf(err, "in %s", node.Content[i].Value)
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := e.expand(item); err != nil {
+				return errors.Wrapf(err, "in item %d", i)
+			}
+		}
+	case yaml.ScalarNode:
+		return e.expandScalar(node)
+	}
+	if node.Tag == fileTag {
+		return errors.Errorf("line %d: %s must be followed by a path", node.Line, fileTag)
+	}
+	return nil
+}
+
+func (e *manifestExpander) expandScalar(node *yaml.Node) error {
+	if node.Tag == fileTag {
+		content, err := e.readFile(node.Value)
+		if err != nil {
+			return err
+		}
+		node.Tag = "!!str"
+		node.Value = content
+		// The encoder picks a style that keeps the contents a string.
+		node.Style = 0
+		return nil
+	}
+	if node.Tag != "!!str" || !strings.Contains(node.Value, "${") {
+		return nil
+	}
+
+	expanded, err := expandEnv(node.Value, e.lookupEnv)
+	if err != nil {
+		return err
+	}
+	node.Value = expanded
+	if node.Style == 0 {
+		// Resolve the expanded text like a plain value in the manifest.
+		node.Tag = ""
+	}
+	return nil
+}
+
+// readFile returns the contents of the file referenced by path, which may
+// itself contain environment variable references.
+func (e *manifestExpander) readFile(path string) (string, error) {
+	path, err := expandEnv(path, e.lookupEnv)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(e.baseDir, path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read referenced file %s", path)
+	}
+	return strings.TrimSuffix(string(content), "\n"), nil
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} in s.
+func expandEnv(s string, lookupEnv func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1])
+			b.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return "", errors.Errorf("unterminated variable reference in %q", s)
+		}
+		b.WriteString(s[:start])
+
+		reference := s[start+2 : start+end]
+		name, fallback, hasFallback := strings.Cut(reference, ":-")
+		if name == "" {
+			return "", errors.Errorf("empty variable reference in %q", s)
+		}
+		value, ok := lookupEnv(name)
+		switch {
+		case ok && value != "":
+		case hasFallback:
+			value = fallback
+		case !ok:
+			return "", errors.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+}
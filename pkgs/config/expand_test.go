@@ -0,0 +1,179 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestExpandManifest(t *testing.T) {
+	env := map[string]string{
+		"PORT":   "8080",
+		"SECRET": "0123",
+		"HEX":    "0x1F",
+		"DIR":    "secrets",
+		"EMPTY":  "",
+		"TEXT":   "a: b # c",
+	}
+	files := map[string]string{
+		"secrets/number":   "0123\n",
+		"secrets/template": "${PORT}\n",
+		"secrets/lines":    "first\nsecond\n",
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		// want is the manifest as it would have been written without
+		// references.
+		want    string
+		wantErr bool
+	}{
+		{
+			name:     "scalars without references keep their text",
+			manifest: "env:\n  A: 0123\n  B: 1.10\n  Y: yes\n  C: '0123'\nlist: [0x1F, 1e3, ~]\n",
+			want:     "env:\n  A: 0123\n  B: 1.10\n  Y: yes\n  C: '0123'\nlist: [0x1F, 1e3, ~]\n",
+		},
+		{
+			name:     "plain reference is typed like plain text",
+			manifest: "port: ${PORT}\n",
+			want:     "port: 8080\n",
+		},
+		{
+			name:     "quoted reference stays a string",
+			manifest: "a: \"${SECRET}\"\nb: '${HEX}'\n",
+			want:     "a: \"0123\"\nb: \"0x1F\"\n",
+		},
+		{
+			name:     "reference inside text",
+			manifest: "path: ${DIR}/exporter\n",
+			want:     "path: secrets/exporter\n",
+		},
+		{
+			name:     "value with YAML syntax stays one value",
+			manifest: "a: ${TEXT}\n",
+			want:     "a: 'a: b # c'\n",
+		},
+		{
+			name:     "default",
+			manifest: "a: ${UNSET:-tcp}\nb: ${EMPTY:-unix}\n",
+			want:     "a: tcp\nb: unix\n",
+		},
+		{
+			name:     "escaped reference",
+			manifest: "a: \"$${literal}\"\n",
+			want:     "a: \"${literal}\"\n",
+		},
+		{
+			name:     "keys are not expanded",
+			manifest: "${PORT}: a\n",
+			want:     "${PORT}: a\n",
+		},
+		{
+			name:     "file reference is a string",
+			manifest: "a: !file secrets/number\nlist:\n  - !file secrets/number\n",
+			want:     "a: \"0123\"\nlist:\n  - \"0123\"\n",
+		},
+		{
+			name:     "file path with reference",
+			manifest: "a: !file ${DIR}/lines\n",
+			want:     "a: \"first\\nsecond\"\n",
+		},
+		{
+			name:     "file contents are not expanded",
+			manifest: "a: !file secrets/template\n",
+			want:     "a: \"${PORT}\"\n",
+		},
+		{
+			name:     "file tag inside quoted string",
+			manifest: "a: \"b: !file secrets/number\"\n",
+			want:     "a: \"b: !file secrets/number\"\n",
+		},
+		{
+			name:     "unset variable",
+			manifest: "a: ${UNSET}\n",
+			wantErr:  true,
+		},
+		{
+			name:     "unterminated reference",
+			manifest: "a: ${PORT\n",
+			wantErr:  true,
+		},
+		{
+			name:     "missing file",
+			manifest: "a: !file secrets/missing\n",
+			wantErr:  true,
+		},
+		{
+			name:     "file tag on a mapping",
+			manifest: "a: !file\n  b: c\n",
+			wantErr:  true,
+		},
+	}
+
+	baseDir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expanded, err := expandManifest([]byte(tt.manifest), baseDir, lookupEnv)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var got, want interface{}
+			if err := yaml.Unmarshal(expanded, &got); err != nil {
+				t.Fatalf("failed to parse expanded manifest %q: %v", expanded, err)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("failed to parse wanted manifest: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expandManifest() = %q, want %q", expanded, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandManifestStringFields(t *testing.T) {
+	lookupEnv := func(name string) (string, bool) {
+		return map[string]string{"A": "0123", "B": "1e3", "C": "0x1F"}[name], true
+	}
+	manifest := "a: ${A}\nb: ${B}\nc: ${C}\nd: 1.10\nenv:\n  Y: 0123\n"
+
+	expanded, err := expandManifest([]byte(manifest), t.TempDir(), lookupEnv)
+	if err != nil {
+		t.Fatalf("expandManifest() error = %v", err)
+	}
+	var got struct {
+		A   string            `yaml:"a"`
+		B   string            `yaml:"b"`
+		C   string            `yaml:"c"`
+		D   string            `yaml:"d"`
+		Env map[string]string `yaml:"env"`
+	}
+	if err := yaml.Unmarshal(expanded, &got); err != nil {
+		t.Fatalf("failed to parse expanded manifest %q: %v", expanded, err)
+	}
+	if got.A != "0123" || got.B != "1e3" || got.C != "0x1F" || got.D != "1.10" || got.Env["Y"] != "0123" {
+		t.Errorf("string fields = %+v, want the values as written", got)
+	}
+}
@@ -3,10 +3,15 @@ package config
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -16,6 +21,249 @@ type ManifestPlugin struct {
 	Name string `yaml:"name"`
 	Path string `yaml:"path"`
 	Kind string `yaml:"kind"`
+	// Transport overrides ManifestConfig.Transport for this plugin.
+	Transport string `yaml:"transport"`
+	// TLS holds pre-issued certificates for this plugin. Only used together
+	// with TLSConfig.UseCustomTLS.
+	TLS *PluginTLSConfig `yaml:"tls"`
+	// Restart controls what happens when the plugin process exits on its own.
+	Restart *RestartPolicy `yaml:"restart"`
+	// StartupTimeout bounds how long the plugin may take to report SERVING,
+	// including compilation for kind "build_and_run". Defaults to 10s.
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+	// Required makes LoadAll fail when this plugin cannot be loaded. Optional
+	// plugins that fail are left out and reported instead. Defaults to true.
+	Required *bool `yaml:"required"`
+	// Config holds the plugin's own settings. It is sent to the plugin over
+	// an inherited pipe and shows up as plugin.PluginOptions.Config.
+	Config PluginConfig `yaml:"config"`
+	// Env controls the environment of the plugin process. By default it
+	// inherits the whole environment of the runner.
+	Env *PluginEnv `yaml:"env"`
+	// Args are passed to the plugin after the flags set by the runner.
+	Args []string `yaml:"args"`
+	// Workdir is the working directory of the plugin process. Defaults to
+	// the plugin's directory, or the directory of the binary for kind "exec".
+	Workdir string `yaml:"workdir"`
+}
+
+// PluginEnv describes the environment of a plugin process.
+type PluginEnv struct {
+	// Inherit is "all" (default) to pass on the runner's whole environment
+	// or "allowlist" to pass on only the variables listed in Allow.
+	Inherit string `yaml:"inherit"`
+	// Allow lists the names of the variables inherited with "allowlist".
+	Allow []string `yaml:"allow"`
+	// Vars are set in addition to the inherited variables and take
+	// precedence over them.
+	Vars map[string]string `yaml:"vars"`
+}
+
+// Environ returns the environment of a plugin process started by a runner
+// whose environment is host, in the form of os.Environ.
+func (e *PluginEnv) Environ(host []string) []string {
+	if e == nil {
+		return host
+	}
+
+	var env []string
+	switch e.Inherit {
+	case "allowlist":
+		allowed := make(map[string]struct{}, len(e.Allow))
+		for _, name := range e.Allow {
+			allowed[name] = struct{}{}
+		}
+		for _, entry := range host {
+			name, _, _ := strings.Cut(entry, "=")
+			if _, ok := allowed[name]; ok {
+				env = append(env, entry)
+			}
+		}
+	default:
+		env = append(env, host...)
+	}
+
+	names := make([]string, 0, len(e.Vars))
+	for name := range e.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// exec.Cmd keeps the last value of duplicated variables, so these
+	// override inherited ones.
+	for _, name := range names {
+		env = append(env, name+"="+e.Vars[name])
+	}
+	return env
+}
+
+func (e *PluginEnv) Validate() error {
+	switch e.Inherit {
+	case "", "all":
+		if len(e.Allow) > 0 {
+			return errors.New("allow requires inherit: allowlist")
+		}
+	case "allowlist":
+	default:
+		return errors.Errorf("unsupported env inherit mode: %q", e.Inherit)
+	}
+	for _, name := range e.Allow {
+		if name == "" || strings.Contains(name, "=") {
+			return errors.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	for name := range e.Vars {
+		if name == "" || strings.Contains(name, "=") {
+			return errors.Errorf("invalid environment variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// PluginConfig is an arbitrary settings document for a plugin. Values must be
+// representable in JSON.
+type PluginConfig map[string]any
+
+func (c *PluginConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[interface{}]interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	value, err := normalizeYAML(raw)
+	if err != nil {
+		return err
+	}
+	*c = value.(map[string]any)
+	return nil
+}
+
+// normalizeYAML turns the map[interface{}]interface{} values produced by
+// yaml.v2 into map[string]any so they can be encoded as JSON.
+func normalizeYAML(value interface{}) (any, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]any, len(value))
+		for key, item := range value {
+			// JSON objects only have string keys.
+			keyString := fmt.Sprint(key)
+			item, err := normalizeYAML(item)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid config value for %q", keyString)
+			}
+			normalized[keyString] = item
+		}
+		return normalized, nil
+	case []interface{}:
+		normalized := make([]any, len(value))
+		for i, item := range value {
+			item, err := normalizeYAML(item)
+			if err != nil {
+				return nil, err
+			}
+			normalized[i] = item
+		}
+		return normalized, nil
+	default:
+		return value, nil
+	}
+}
+
+const (
+	defaultRestartInitialBackoff = time.Second
+	defaultRestartMaxBackoff     = 30 * time.Second
+)
+
+// RestartPolicy describes if and how a plugin is restarted after its process
+// exits without being closed by the runner.
+type RestartPolicy struct {
+	// Policy is one of "never" (default), "on-failure" (non-zero exit or
+	// killed by a signal) or "always".
+	Policy string `yaml:"policy"`
+	// MaxRetries limits consecutive restarts. Zero means no limit.
+	MaxRetries int `yaml:"max_retries"`
+	// InitialBackoff is the delay before the first restart. It doubles with
+	// every consecutive restart up to MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// ShouldRestart reports whether a plugin that exited with exitErr (nil for a
+// clean exit) after attempts consecutive restarts is started again.
+func (r *RestartPolicy) ShouldRestart(exitErr error, attempts int) bool {
+	if r == nil {
+		return false
+	}
+	if r.MaxRetries > 0 && attempts >= r.MaxRetries {
+		return false
+	}
+	switch r.Policy {
+	case "always":
+		return true
+	case "on-failure":
+		return exitErr != nil
+	default:
+		return false
+	}
+}
+
+// Backoff returns the delay before restart number attempt, counted from zero.
+func (r *RestartPolicy) Backoff(attempt int) time.Duration {
+	initial, maxBackoff := defaultRestartInitialBackoff, defaultRestartMaxBackoff
+	if r != nil && r.InitialBackoff > 0 {
+		initial = r.InitialBackoff
+	}
+	if r != nil && r.MaxBackoff > 0 {
+		maxBackoff = r.MaxBackoff
+	}
+
+	backoff := initial
+	for i := 0; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+func (r *RestartPolicy) Validate() error {
+	switch r.Policy {
+	case "", "never", "on-failure", "always":
+	default:
+		return errors.Errorf("unsupported restart policy: %q", r.Policy)
+	}
+	if r.MaxRetries < 0 {
+		return errors.New("max_retries cannot be negative")
+	}
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("restart backoff cannot be negative")
+	}
+	return nil
+}
+
+// PluginTLSConfig points to PEM encoded certificate and key pairs issued to a
+// plugin ahead of time. Pairs that are not set are issued from the CA.
+type PluginTLSConfig struct {
+	ServerCertPath string `yaml:"server_cert_path"`
+	ServerKeyPath  string `yaml:"server_key_path"`
+	ClientCertPath string `yaml:"client_cert_path"`
+	ClientKeyPath  string `yaml:"client_key_path"`
+}
+
+// HasServerCert reports whether a server certificate was pre-issued.
+func (c *PluginTLSConfig) HasServerCert() bool {
+	return c != nil && c.ServerCertPath != ""
+}
+
+// HasClientCert reports whether a client certificate was pre-issued.
+func (c *PluginTLSConfig) HasClientCert() bool {
+	return c != nil && c.ClientCertPath != ""
+}
+
+func (c *PluginTLSConfig) Validate() error {
+	if (c.ServerCertPath == "") != (c.ServerKeyPath == "") {
+		return errors.New("server_cert_path and server_key_path must be set together")
+	}
+	if (c.ClientCertPath == "") != (c.ClientKeyPath == "") {
+		return errors.New("client_cert_path and client_key_path must be set together")
+	}
+	return nil
 }
 
 func generateRandomName() string {
@@ -42,20 +290,96 @@ func (p *ManifestPlugin) GetName() string {
 	return name
 }
 
+// IsRequired reports whether the plugin must load for LoadAll to succeed.
+func (p *ManifestPlugin) IsRequired() bool {
+	return p.Required == nil || *p.Required
+}
+
+// GetTransport returns the plugin's transport, falling back to
+// defaultTransport and finally to "tcp".
+func (p *ManifestPlugin) GetTransport(defaultTransport string) string {
+	if p.Transport != "" {
+		return p.Transport
+	}
+	if defaultTransport != "" {
+		return defaultTransport
+	}
+	return "tcp"
+}
+
+func validateTransport(transport string) error {
+	switch transport {
+	case "", "tcp", "unix":
+		return nil
+	default:
+		return errors.Errorf("unsupported transport: %q", transport)
+	}
+}
+
+// validateRelativePaths refuses relative plugin and workdir paths containing
+// "..", unless GRPC_PLUGINS_ALLOW_RELATIVE_PATHS_DOUBLE_DOT is "true".
+func (p *ManifestPlugin) validateRelativePaths() error {
+	if os.Getenv("GRPC_PLUGINS_ALLOW_RELATIVE_PATHS_DOUBLE_DOT") == "true" {
+		return nil
+	}
+	if !filepath.IsAbs(p.Path) && strings.Contains(p.Path, "..") {
+		return errors.New("plugin path cannot contain '..'")
+	}
+	if !filepath.IsAbs(p.Workdir) && strings.Contains(p.Workdir, "..") {
+		return errors.New("plugin workdir cannot contain '..'")
+	}
+	return nil
+}
+
 func (p *ManifestPlugin) Validate() error {
 	if p.Path == "" {
 		return errors.New("plugin path cannot be empty")
 	}
 
-	if !filepath.IsAbs(p.Path) {
-		if strings.Contains(p.Path, "..") && os.Getenv("GRPC_PLUGINS_ALLOW_RELATIVE_PATHS_DOUBLE_DOT") != "true" {
-			return errors.New("plugin path cannot contain '..'")
+	if err := p.validateRelativePaths(); err != nil {
+		return err
+	}
+
+	if err := validateTransport(p.Transport); err != nil {
+		return err
+	}
+
+	if p.TLS != nil {
+		if err := p.TLS.Validate(); err != nil {
+			return errors.Wrap(err, "invalid plugin TLS configuration")
+		}
+	}
+
+	if p.StartupTimeout < 0 {
+		return errors.New("startup_timeout cannot be negative")
+	}
+
+	if p.Restart != nil {
+		if err := p.Restart.Validate(); err != nil {
+			return errors.Wrap(err, "invalid restart policy")
+		}
+	}
+
+	if p.Env != nil {
+		if err := p.Env.Validate(); err != nil {
+			return errors.Wrap(err, "invalid plugin env")
+		}
+	}
+
+	if p.Workdir != "" {
+		if p.Kind == "build_and_run" {
+			return errors.New("workdir is not supported for kind build_and_run, use kind build")
+		}
+	}
+
+	if len(p.Config) > 0 {
+		if _, err := json.Marshal(p.Config); err != nil {
+			return errors.Wrap(err, "plugin config cannot be encoded as JSON")
 		}
 	}
 
 	switch p.Kind {
-	case "build_and_run":
-		// Currently the only supported kind
+	case "build_and_run", "build", "exec":
 		return nil
 	case "":
 		return errors.New("plugin kind cannot be empty")
@@ -65,23 +389,104 @@ func (p *ManifestPlugin) Validate() error {
 }
 
 type TLSConfig struct {
+	// UseCustomTLS makes plugin certificates chain to the CA at CACertPath
+	// instead of a CA generated in memory for every runner.
 	UseCustomTLS bool `yaml:"use_custom_tls"`
+	// CACertPath is a PEM file with the certificate of the CA that issues
+	// plugin certificates, e.g. an intermediate of the organisation's PKI.
+	// Runner and plugins trust only this certificate.
+	CACertPath string `yaml:"ca_cert_path"`
+	// CAKeyPath is the PEM encoded RSA private key of the CA. Without it,
+	// every plugin needs pre-issued certificates.
+	CAKeyPath string `yaml:"ca_key_path"`
+}
+
+// CanIssue reports whether certificates can be issued for plugins that have
+// none pre-issued.
+func (c *TLSConfig) CanIssue() bool {
+	return !c.UseCustomTLS || c.CAKeyPath != ""
 }
 
 func (c *TLSConfig) Validate() error {
-	// Currently no validation needed for TLS config
+	if c.UseCustomTLS {
+		if c.CACertPath == "" {
+			return errors.New("ca_cert_path is required when use_custom_tls is set")
+		}
+	} else if c.CACertPath != "" || c.CAKeyPath != "" {
+		return errors.New("ca_cert_path and ca_key_path require use_custom_tls")
+	}
+
+	return nil
+}
+
+// ValidatePlugin checks that the TLS settings of plugin work with this
+// configuration.
+func (c *TLSConfig) ValidatePlugin(plugin *ManifestPlugin) error {
+	if plugin.TLS != nil && !c.UseCustomTLS {
+		return errors.Errorf("plugin %q has TLS certificates but use_custom_tls is not set", plugin.GetName())
+	}
+	if !c.CanIssue() && (!plugin.TLS.HasServerCert() || !plugin.TLS.HasClientCert()) {
+		return errors.Errorf("plugin %q needs pre-issued server and client certificates when ca_key_path is not set", plugin.GetName())
+	}
 	return nil
 }
 
 type ManifestConfig struct {
 	Plugins []ManifestPlugin `yaml:"plugins"`
 	TLS     TLSConfig        `yaml:"tls"`
+	// Transport is either "tcp" (default) or "unix" for every plugin that
+	// does not set its own.
+	Transport string `yaml:"transport"`
+	// BuildCacheDir is where plugins of kind "build" are compiled to. When
+	// empty, a directory below the user cache directory is used.
+	BuildCacheDir string `yaml:"build_cache_dir"`
+	// MaxParallelLoads limits how many plugins are started at the same time.
+	// Defaults to the number of CPUs.
+	MaxParallelLoads int `yaml:"max_parallel_loads"`
+	// ShutdownGracePeriod is how long a plugin may take to exit after
+	// SIGTERM before it is killed. Defaults to 10s.
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period"`
+}
+
+const defaultShutdownGracePeriod = 10 * time.Second
+
+// GetShutdownGracePeriod returns ShutdownGracePeriod or its default.
+func (c *ManifestConfig) GetShutdownGracePeriod() time.Duration {
+	if c.ShutdownGracePeriod > 0 {
+		return c.ShutdownGracePeriod
+	}
+	return defaultShutdownGracePeriod
+}
+
+// GetMaxParallelLoads returns MaxParallelLoads or its default.
+func (c *ManifestConfig) GetMaxParallelLoads() int {
+	if c.MaxParallelLoads > 0 {
+		return c.MaxParallelLoads
+	}
+	return runtime.NumCPU()
 }
 
 func (c *ManifestConfig) Validate() error {
 	if len(c.Plugins) == 0 {
 		return errors.New("manifest must contain at least one plugin")
 	}
+	return c.validate()
+}
+
+// validate checks everything Validate does but the number of plugins. A
+// "directory" manifest may be empty until plugins are placed in it.
+func (c *ManifestConfig) validate() error {
+	if err := validateTransport(c.Transport); err != nil {
+		return err
+	}
+
+	if c.MaxParallelLoads < 0 {
+		return errors.New("max_parallel_loads cannot be negative")
+	}
+
+	if c.ShutdownGracePeriod < 0 {
+		return errors.New("shutdown_grace_period cannot be negative")
+	}
 
 	seenNames := make(map[string]struct{})
 	seenPaths := make(map[string]struct{})
@@ -111,16 +516,75 @@ func (c *ManifestConfig) Validate() error {
 		return errors.Wrap(err, "invalid TLS configuration")
 	}
 
+	for i := range c.Plugins {
+		if err := c.TLS.ValidatePlugin(&c.Plugins[i]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 type Manifest struct {
-	Kind   string          // can be "file" or "inline"
-	Path   string          // if kind is "file", this is the path to the file
-	Config *ManifestConfig // if kind is "inline", this is the config for the plugin
+	Kind   string          // can be "file", "inline" or "directory"
+	Path   string          // if kind is "file" or "directory", this is the path to the file or directory
+	Config *ManifestConfig // if kind is "inline", this is the config for the plugin; if kind is "directory", optional settings shared by the discovered plugins
+	// Glob limits a "directory" manifest to the entries whose name matches
+	// it, see filepath.Match. Defaults to every entry.
+	Glob string
+	// Watch makes the runner poll the file of a "file" manifest or the
+	// descriptors of a "directory" manifest and bring the running plugins in
+	// line with it whenever it changes. Replacing a plugin's binary without
+	// touching its entry is not a change, reload the plugin to pick it up.
+	Watch bool
+	// WatchInterval is how often a watched manifest is checked. Defaults to
+	// 2s.
+	WatchInterval time.Duration
+	// OnEvent, if set, is called for every change applied from a watched
+	// manifest and for every edit that was rejected.
+	OnEvent func(ManifestEvent)
+}
+
+// ManifestAction is what the runner did in reaction to a manifest change.
+type ManifestAction string
+
+const (
+	ManifestActionLoad   ManifestAction = "load"
+	ManifestActionUnload ManifestAction = "unload"
+	ManifestActionReload ManifestAction = "reload"
+	// ManifestActionReject means the edited manifest was invalid and nothing
+	// was changed.
+	ManifestActionReject ManifestAction = "reject"
+)
+
+// ManifestEvent reports one action taken for a watched manifest.
+type ManifestEvent struct {
+	Action ManifestAction
+	// Plugin is the affected plugin, empty for ManifestActionReject.
+	Plugin string
+	// Err is set if the action failed or, for ManifestActionReject, why the
+	// manifest was rejected.
+	Err error
+}
+
+const defaultWatchInterval = 2 * time.Second
+
+// GetWatchInterval returns WatchInterval or its default.
+func (m *Manifest) GetWatchInterval() time.Duration {
+	if m.WatchInterval > 0 {
+		return m.WatchInterval
+	}
+	return defaultWatchInterval
 }
 
 func (m *Manifest) Validate() error {
+	if m.Watch && m.Kind != "file" && m.Kind != "directory" {
+		return errors.New("only manifests of kind 'file' or 'directory' can be watched")
+	}
+	if m.WatchInterval < 0 {
+		return errors.New("watch interval cannot be negative")
+	}
+
 	switch m.Kind {
 	case "file":
 		if m.Path == "" {
@@ -137,6 +601,24 @@ func (m *Manifest) Validate() error {
 			return errors.New("manifest config cannot be nil for kind 'inline'")
 		}
 		return nil
+	case "directory":
+		if m.Path == "" {
+			return errors.New("manifest path cannot be empty for kind 'directory'")
+		}
+		if !filepath.IsAbs(m.Path) {
+			if strings.Contains(m.Path, "..") {
+				return errors.New("manifest path cannot contain '..'")
+			}
+		}
+		if m.Glob != "" {
+			if _, err := filepath.Match(m.Glob, ""); err != nil {
+				return errors.Wrapf(err, "invalid manifest glob %q", m.Glob)
+			}
+		}
+		if m.Config != nil && len(m.Config.Plugins) > 0 {
+			return errors.New("manifest config cannot list plugins for kind 'directory'")
+		}
+		return nil
 	case "":
 		return errors.New("manifest kind cannot be empty")
 	default:
@@ -159,6 +641,12 @@ func LoadManifestFile[T any](cfg *Config[T]) (*ManifestConfig, error) {
 		return nil, errors.Wrapf(err, "failed to read manifest file at %s", cfg.Manifest.Path)
 	}
 
+	configFile, err = expandManifest(configFile, filepath.Dir(cfg.Manifest.Path), os.LookupEnv)
+	if err != nil {
+		logger.Error("failed to expand manifest file", "error", err)
+		return nil, errors.Wrapf(err, "failed to expand manifest file at %s", cfg.Manifest.Path)
+	}
+
 	var pluginConfig ManifestConfig
 	if err := yaml.Unmarshal(configFile, &pluginConfig); err != nil {
 		logger.Error("failed to unmarshal manifest file", "error", err)
@@ -213,6 +701,8 @@ func LoadManifest[T any](cfg *Config[T]) (*ManifestConfig, error) {
 		result, err = LoadManifestInline(cfg)
 	case "file":
 		result, err = LoadManifestFile(cfg)
+	case "directory":
+		result, err = LoadManifestDirectory(cfg)
 	default:
 		// This should never happen due to Validate() check above
 		logger.Error("unsupported manifest kind", "kind", cfg.Manifest.Kind)
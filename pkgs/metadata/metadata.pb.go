@@ -0,0 +1,394 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v6.31.1
+// source: metadata.proto
+
+package metadata
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DescribeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DescribeRequest) Reset() {
+	*x = DescribeRequest{}
+	mi := &file_metadata_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeRequest) ProtoMessage() {}
+
+func (x *DescribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_metadata_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeRequest.ProtoReflect.Descriptor instead.
+func (*DescribeRequest) Descriptor() ([]byte, []int) {
+	return file_metadata_proto_rawDescGZIP(), []int{0}
+}
+
+type DescribeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Name is the name the runner gave the plugin.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Version is the plugin's own version, see plugin.StartOptions.Version.
+	Version string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	// ProtocolVersion is the protocol version the plugin declared, 0 for none.
+	ProtocolVersion int32 `protobuf:"varint,3,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	// BuildInfo is read from the plugin binary, it is unset if the binary
+	// was built without module support.
+	BuildInfo *BuildInfo `protobuf:"bytes,4,opt,name=build_info,json=buildInfo,proto3" json:"build_info,omitempty"`
+	// Services lists the fully qualified names of the gRPC services the
+	// plugin serves.
+	Services []string `protobuf:"bytes,5,rep,name=services,proto3" json:"services,omitempty"`
+	// Labels are set by the plugin, see plugin.StartOptions.Labels.
+	Labels        map[string]string `protobuf:"bytes,6,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DescribeResponse) Reset() {
+	*x = DescribeResponse{}
+	mi := &file_metadata_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DescribeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DescribeResponse) ProtoMessage() {}
+
+func (x *DescribeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_metadata_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DescribeResponse.ProtoReflect.Descriptor instead.
+func (*DescribeResponse) Descriptor() ([]byte, []int) {
+	return file_metadata_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DescribeResponse) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DescribeResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *DescribeResponse) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *DescribeResponse) GetBuildInfo() *BuildInfo {
+	if x != nil {
+		return x.BuildInfo
+	}
+	return nil
+}
+
+func (x *DescribeResponse) GetServices() []string {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+func (x *DescribeResponse) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+// BuildInfo mirrors debug.BuildInfo.
+type BuildInfo struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	GoVersion string                 `protobuf:"bytes,1,opt,name=go_version,json=goVersion,proto3" json:"go_version,omitempty"`
+	// Path is the package path of the main package.
+	Path string    `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	Main *Module   `protobuf:"bytes,3,opt,name=main,proto3" json:"main,omitempty"`
+	Deps []*Module `protobuf:"bytes,4,rep,name=deps,proto3" json:"deps,omitempty"`
+	// Settings hold build settings such as vcs.revision or -tags.
+	Settings      map[string]string `protobuf:"bytes,5,rep,name=settings,proto3" json:"settings,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BuildInfo) Reset() {
+	*x = BuildInfo{}
+	mi := &file_metadata_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BuildInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BuildInfo) ProtoMessage() {}
+
+func (x *BuildInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_metadata_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BuildInfo.ProtoReflect.Descriptor instead.
+func (*BuildInfo) Descriptor() ([]byte, []int) {
+	return file_metadata_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BuildInfo) GetGoVersion() string {
+	if x != nil {
+		return x.GoVersion
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetMain() *Module {
+	if x != nil {
+		return x.Main
+	}
+	return nil
+}
+
+func (x *BuildInfo) GetDeps() []*Module {
+	if x != nil {
+		return x.Deps
+	}
+	return nil
+}
+
+func (x *BuildInfo) GetSettings() map[string]string {
+	if x != nil {
+		return x.Settings
+	}
+	return nil
+}
+
+// Module mirrors debug.Module.
+type Module struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Version       string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	Sum           string                 `protobuf:"bytes,3,opt,name=sum,proto3" json:"sum,omitempty"`
+	Replace       *Module                `protobuf:"bytes,4,opt,name=replace,proto3" json:"replace,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Module) Reset() {
+	*x = Module{}
+	mi := &file_metadata_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Module) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Module) ProtoMessage() {}
+
+func (x *Module) ProtoReflect() protoreflect.Message {
+	mi := &file_metadata_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Module.ProtoReflect.Descriptor instead.
+func (*Module) Descriptor() ([]byte, []int) {
+	return file_metadata_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Module) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Module) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Module) GetSum() string {
+	if x != nil {
+		return x.Sum
+	}
+	return ""
+}
+
+func (x *Module) GetReplace() *Module {
+	if x != nil {
+		return x.Replace
+	}
+	return nil
+}
+
+var File_metadata_proto protoreflect.FileDescriptor
+
+const file_metadata_proto_rawDesc = "" +
+	"\n" +
+	"\x0emetadata.proto\x12\x16grpcplugin.metadata.v1\"\x11\n" +
+	"\x0fDescribeRequest\"\xd2\x02\n" +
+	"\x10DescribeResponse\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12)\n" +
+	"\x10protocol_version\x18\x03 \x01(\x05R\x0fprotocolVersion\x12@\n" +
+	"\n" +
+	"build_info\x18\x04 \x01(\v2!.grpcplugin.metadata.v1.BuildInfoR\tbuildInfo\x12\x1a\n" +
+	"\bservices\x18\x05 \x03(\tR\bservices\x12L\n" +
+	"\x06labels\x18\x06 \x03(\v24.grpcplugin.metadata.v1.DescribeResponse.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb0\x02\n" +
+	"\tBuildInfo\x12\x1d\n" +
+	"\n" +
+	"go_version\x18\x01 \x01(\tR\tgoVersion\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x122\n" +
+	"\x04main\x18\x03 \x01(\v2\x1e.grpcplugin.metadata.v1.ModuleR\x04main\x122\n" +
+	"\x04deps\x18\x04 \x03(\v2\x1e.grpcplugin.metadata.v1.ModuleR\x04deps\x12K\n" +
+	"\bsettings\x18\x05 \x03(\v2/.grpcplugin.metadata.v1.BuildInfo.SettingsEntryR\bsettings\x1a;\n" +
+	"\rSettingsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x82\x01\n" +
+	"\x06Module\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\x10\n" +
+	"\x03sum\x18\x03 \x01(\tR\x03sum\x128\n" +
+	"\areplace\x18\x04 \x01(\v2\x1e.grpcplugin.metadata.v1.ModuleR\areplace2i\n" +
+	"\bMetadata\x12]\n" +
+	"\bDescribe\x12'.grpcplugin.metadata.v1.DescribeRequest\x1a(.grpcplugin.metadata.v1.DescribeResponseB/Z-github.com/trustdsh/grpc-plugin/pkgs/metadatab\x06proto3"
+
+var (
+	file_metadata_proto_rawDescOnce sync.Once
+	file_metadata_proto_rawDescData []byte
+)
+
+func file_metadata_proto_rawDescGZIP() []byte {
+	file_metadata_proto_rawDescOnce.Do(func() {
+		file_metadata_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_metadata_proto_rawDesc), len(file_metadata_proto_rawDesc)))
+	})
+	return file_metadata_proto_rawDescData
+}
+
+var file_metadata_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_metadata_proto_goTypes = []any{
+	(*DescribeRequest)(nil),  // 0: grpcplugin.metadata.v1.DescribeRequest
+	(*DescribeResponse)(nil), // 1: grpcplugin.metadata.v1.DescribeResponse
+	(*BuildInfo)(nil),        // 2: grpcplugin.metadata.v1.BuildInfo
+	(*Module)(nil),           // 3: grpcplugin.metadata.v1.Module
+	nil,                      // 4: grpcplugin.metadata.v1.DescribeResponse.LabelsEntry
+	nil,                      // 5: grpcplugin.metadata.v1.BuildInfo.SettingsEntry
+}
+var file_metadata_proto_depIdxs = []int32{
+	2, // 0: grpcplugin.metadata.v1.DescribeResponse.build_info:type_name -> grpcplugin.metadata.v1.BuildInfo
+	4, // 1: grpcplugin.metadata.v1.DescribeResponse.labels:type_name -> grpcplugin.metadata.v1.DescribeResponse.LabelsEntry
+	3, // 2: grpcplugin.metadata.v1.BuildInfo.main:type_name -> grpcplugin.metadata.v1.Module
+	3, // 3: grpcplugin.metadata.v1.BuildInfo.deps:type_name -> grpcplugin.metadata.v1.Module
+	5, // 4: grpcplugin.metadata.v1.BuildInfo.settings:type_name -> grpcplugin.metadata.v1.BuildInfo.SettingsEntry
+	3, // 5: grpcplugin.metadata.v1.Module.replace:type_name -> grpcplugin.metadata.v1.Module
+	0, // 6: grpcplugin.metadata.v1.Metadata.Describe:input_type -> grpcplugin.metadata.v1.DescribeRequest
+	1, // 7: grpcplugin.metadata.v1.Metadata.Describe:output_type -> grpcplugin.metadata.v1.DescribeResponse
+	7, // [7:8] is the sub-list for method output_type
+	6, // [6:7] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_metadata_proto_init() }
+func file_metadata_proto_init() {
+	if File_metadata_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_metadata_proto_rawDesc), len(file_metadata_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_metadata_proto_goTypes,
+		DependencyIndexes: file_metadata_proto_depIdxs,
+		MessageInfos:      file_metadata_proto_msgTypes,
+	}.Build()
+	File_metadata_proto = out.File
+	file_metadata_proto_goTypes = nil
+	file_metadata_proto_depIdxs = nil
+}
@@ -0,0 +1,127 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v6.31.1
+// source: metadata.proto
+
+package metadata
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Metadata_Describe_FullMethodName = "/grpcplugin.metadata.v1.Metadata/Describe"
+)
+
+// MetadataClient is the client API for Metadata service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Metadata lets the runner ask a plugin what it is. plugin.StartPlugin
+// registers it for every plugin.
+type MetadataClient interface {
+	Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error)
+}
+
+type metadataClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMetadataClient(cc grpc.ClientConnInterface) MetadataClient {
+	return &metadataClient{cc}
+}
+
+func (c *metadataClient) Describe(ctx context.Context, in *DescribeRequest, opts ...grpc.CallOption) (*DescribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DescribeResponse)
+	err := c.cc.Invoke(ctx, Metadata_Describe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MetadataServer is the server API for Metadata service.
+// All implementations must embed UnimplementedMetadataServer
+// for forward compatibility.
+//
+// Metadata lets the runner ask a plugin what it is. plugin.StartPlugin
+// registers it for every plugin.
+type MetadataServer interface {
+	Describe(context.Context, *DescribeRequest) (*DescribeResponse, error)
+	mustEmbedUnimplementedMetadataServer()
+}
+
+// UnimplementedMetadataServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMetadataServer struct{}
+
+func (UnimplementedMetadataServer) Describe(context.Context, *DescribeRequest) (*DescribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Describe not implemented")
+}
+func (UnimplementedMetadataServer) mustEmbedUnimplementedMetadataServer() {}
+func (UnimplementedMetadataServer) testEmbeddedByValue()                  {}
+
+// UnsafeMetadataServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MetadataServer will
+// result in compilation errors.
+type UnsafeMetadataServer interface {
+	mustEmbedUnimplementedMetadataServer()
+}
+
+func RegisterMetadataServer(s grpc.ServiceRegistrar, srv MetadataServer) {
+	// If the following call pancis, it indicates UnimplementedMetadataServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Metadata_ServiceDesc, srv)
+}
+
+func _Metadata_Describe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetadataServer).Describe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metadata_Describe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetadataServer).Describe(ctx, req.(*DescribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Metadata_ServiceDesc is the grpc.ServiceDesc for Metadata service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Metadata_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "grpcplugin.metadata.v1.Metadata",
+	HandlerType: (*MetadataServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Describe",
+			Handler:    _Metadata_Describe_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "metadata.proto",
+}
@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the settings document the runner passes to the plugin from the
+// `config` block of its manifest entry.
+type Config struct {
+	raw json.RawMessage
+}
+
+// IsEmpty reports whether the manifest entry had no config.
+func (c Config) IsEmpty() bool {
+	return len(c.raw) == 0
+}
+
+// Raw returns the config as JSON, or nil if there is none.
+func (c Config) Raw() []byte {
+	return c.raw
+}
+
+// Decode decodes the config into v with json.Unmarshal, so fields of v are
+// matched by their json tags. An empty config leaves v untouched.
+func (c Config) Decode(v any) error {
+	if c.IsEmpty() {
+		return nil
+	}
+	if err := json.Unmarshal(c.raw, v); err != nil {
+		return errors.Wrap(err, "failed to decode plugin config")
+	}
+	return nil
+}
+
+// DecodeConfig decodes the plugin's config into a new value of type C, see
+// Config.Decode.
+func DecodeConfig[C any](options PluginOptions) (C, error) {
+	var config C
+	err := options.Config.Decode(&config)
+	return config, err
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"sync/atomic"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health reports the plugin's readiness through the standard grpc.health.v1
+// service. The runner hands out a plugin only once it reports SERVING.
+//
+// Unless the plugin reports a status itself while Start runs, it is marked
+// SERVING as soon as Start returns. Plugins that need to warm up call
+// SetNotServing in Start and SetServing once they are ready.
+type Health struct {
+	server   *health.Server
+	reported atomic.Bool
+}
+
+func newHealth() *Health {
+	h := &Health{server: health.NewServer()}
+	h.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return h
+}
+
+// SetServing marks the plugin as ready to handle requests.
+func (h *Health) SetServing() {
+	h.reported.Store(true)
+	h.server.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+}
+
+// SetNotServing marks the plugin as temporarily unable to handle requests.
+func (h *Health) SetNotServing() {
+	h.reported.Store(true)
+	h.server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// markStarted sets the plugin SERVING after Start unless it reported a
+// status on its own.
+func (h *Health) markStarted() {
+	if !h.reported.Load() {
+		h.server.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}
+}
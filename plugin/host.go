@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// dialHost creates a client for the host services described by rawHostInfo,
+// a JSON encoded transport.HostInfo.
+func dialHost(rawHostInfo []byte) (*grpc.ClientConn, error) {
+	var hostInfo transport.HostInfo
+	if err := json.Unmarshal(rawHostInfo, &hostInfo); err != nil {
+		return nil, errors.Wrap(err, "failed to decode host info")
+	}
+	if hostInfo.Network != "tcp" {
+		return nil, errors.Errorf("unsupported host network %q", hostInfo.Network)
+	}
+
+	keyAndCert, err := transport.DeserializeKeyAndCert(hostInfo.KeyAndCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to deserialize host key and cert")
+	}
+	tlsConfig, err := keyAndCert.GetTLSConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get host tls config")
+	}
+	tlsConfig.ServerName = transport.ServerName
+
+	conn, err := grpc.NewClient(hostInfo.Address, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create host client for %s", hostInfo.Address)
+	}
+	return conn, nil
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"runtime/debug"
+	"sort"
+
+	"github.com/trustdsh/grpc-plugin/pkgs/metadata"
+	"google.golang.org/grpc"
+)
+
+// metadataServer answers the runner's questions about the plugin, see
+// LoadedPlugins.Describe.
+type metadataServer struct {
+	metadata.UnimplementedMetadataServer
+
+	name    string
+	options StartOptions
+	server  *grpc.Server
+}
+
+func (m *metadataServer) Describe(context.Context, *metadata.DescribeRequest) (*metadata.DescribeResponse, error) {
+	// Read on every call, so services registered after Start are listed.
+	serviceInfo := m.server.GetServiceInfo()
+	services := make([]string, 0, len(serviceInfo))
+	for name := range serviceInfo {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+
+	response := &metadata.DescribeResponse{
+		Name:            m.name,
+		Version:         m.options.Version,
+		ProtocolVersion: int32(m.options.ProtocolVersion),
+		Services:        services,
+		Labels:          m.options.Labels,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		response.BuildInfo = buildInfoToProto(info)
+	}
+	return response, nil
+}
+
+func buildInfoToProto(info *debug.BuildInfo) *metadata.BuildInfo {
+	settings := make(map[string]string, len(info.Settings))
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+
+	deps := make([]*metadata.Module, 0, len(info.Deps))
+	for _, dep := range info.Deps {
+		deps = append(deps, moduleToProto(dep))
+	}
+
+	return &metadata.BuildInfo{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Main:      moduleToProto(&info.Main),
+		Deps:      deps,
+		Settings:  settings,
+	}
+}
+
+func moduleToProto(module *debug.Module) *metadata.Module {
+	if module == nil {
+		return nil
+	}
+	return &metadata.Module{
+		Path:    module.Path,
+		Version: module.Version,
+		Sum:     module.Sum,
+		Replace: moduleToProto(module.Replace),
+	}
+}
@@ -2,7 +2,9 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"io"
 	"log/slog"
 	"net"
 	"os"
@@ -11,10 +13,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/broker"
 	"github.com/trustdsh/grpc-plugin/internal/transport"
 	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"github.com/trustdsh/grpc-plugin/pkgs/metadata"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const (
@@ -24,12 +30,40 @@ const (
 type PluginOptions struct {
 	Logger *slog.Logger
 	Server *grpc.Server
+	// Health reports readiness to the runner, see Health.
+	Health *Health
+	// Config holds the plugin's settings from the manifest, see Config.
+	Config Config
+	// Host is a client for the services the host offers to its plugins, see
+	// config.Config.HostServices. Nil if the host offers none.
+	Host grpc.ClientConnInterface
+	// Broker hands out sub-connections to the runner and, forwarded by the
+	// runner, to other plugins, see Broker.
+	Broker *Broker
 }
 
+// Broker hands out numbered sub-connections next to the plugin's main
+// connection, e.g. for streaming large payloads on a connection of their own.
+type Broker = broker.Broker
+
 type Plugin interface {
 	Start(PluginOptions)
 }
 
+// StartOptions describe a plugin to the runner, see StartPluginWithOptions.
+type StartOptions struct {
+	// ProtocolVersion is the version of the plugin protocol, e.g. of the
+	// shared proto, the plugin was built against. The runner refuses plugins
+	// whose version the host does not support, see
+	// config.Config.ProtocolVersion. Zero declares no version.
+	ProtocolVersion int
+	// Version is the plugin's own version, reported by the metadata service
+	// together with Labels, e.g. for listing installed plugins.
+	Version string
+	// Labels are free-form key-value pairs describing the plugin.
+	Labels map[string]string
+}
+
 func parseAndSetLoggerOptions(rawLoggerOptions string) {
 	if rawLoggerOptions == "" {
 		return
@@ -68,12 +102,53 @@ func parseAndSetLoggerOptionsAndPluginName(pluginName string, rawLoggerOptions s
 	}
 }
 
+// readSecretsFD reads everything the runner writes to the inherited file
+// descriptor fd. The runner closes its end once done, so this stops at EOF.
+func readSecretsFD(fd int) ([]byte, error) {
+	f := os.NewFile(uintptr(fd), "secrets")
+	if f == nil {
+		return nil, errors.Errorf("invalid file descriptor %d", fd)
+	}
+	if fd != int(os.Stdin.Fd()) {
+		defer f.Close()
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read from file descriptor %d", fd)
+	}
+	return data, nil
+}
+
+// writeHandshakeFD writes handshake to the inherited file descriptor fd and
+// closes it, which tells the runner that nothing else follows.
+func writeHandshakeFD(fd int, handshake *transport.Handshake) error {
+	f := os.NewFile(uintptr(fd), "handshake")
+	if f == nil {
+		return errors.Errorf("invalid file descriptor %d", fd)
+	}
+	defer f.Close()
+	if _, err := f.Write(handshake.Marshal()); err != nil {
+		return errors.Wrapf(err, "failed to write to file descriptor %d", fd)
+	}
+	return nil
+}
+
+// StartPlugin serves plugin without declaring a protocol version.
 func StartPlugin(plugin Plugin) {
+	StartPluginWithOptions(plugin, StartOptions{})
+}
+
+// StartPluginWithOptions serves plugin until the runner stops it.
+func StartPluginWithOptions(plugin Plugin, options StartOptions) {
 	var (
-		port          = flag.Int("port", 50051, "The server port")
-		tlsKeyAndCert = flag.String("tls_key_and_cert", "{}", "The server tls key and cert")
-		pluginName    = flag.String("plugin_name", "", "The name of the plugin")
-		loggerOptions = flag.String("logger_options", "", "The logger options")
+		port            = flag.Int("port", 50051, "The server port")
+		socketPath      = flag.String("socket", "", "The unix socket to listen on instead of the port")
+		tlsKeyAndCertFD = flag.Int("tls_key_and_cert_fd", -1, "The file descriptor to read the server tls key and cert from")
+		pluginName      = flag.String("plugin_name", "", "The name of the plugin")
+		loggerOptions   = flag.String("logger_options", "", "The logger options")
+		configFD        = flag.Int("config_fd", -1, "The file descriptor to read the plugin config from")
+		hostFD          = flag.Int("host_fd", -1, "The file descriptor to read how to reach the host services from")
+		handshakeFD     = flag.Int("handshake_fd", -1, "The file descriptor to report the listening address to, makes the server listen on a port picked by the OS")
 	)
 
 	flag.Parse()
@@ -96,19 +171,91 @@ func StartPlugin(plugin Plugin) {
 		cancel()
 	}()
 
-	keyAndCert, err := transport.DeserializeKeyAndCert([]byte(*tlsKeyAndCert))
+	// There is deliberately no -tls_key_and_cert flag: other local users can
+	// read the command line, and runners that only passed the key that way
+	// predate the handshake, so they cannot start this plugin anyway.
+	if *tlsKeyAndCertFD < 0 {
+		logger.Error("no tls key and cert, plugins must be started by a runner")
+		return
+	}
+	rawKeyAndCert, err := readSecretsFD(*tlsKeyAndCertFD)
+	if err != nil {
+		logger.Error("failed to read tls key and cert", "error", err, "fd", *tlsKeyAndCertFD)
+		return
+	}
+
+	// The runner writes the config pipe after the secrets pipe, so it must be
+	// read second.
+	var pluginConfig Config
+	if *configFD >= 0 {
+		rawConfig, err := readSecretsFD(*configFD)
+		if err != nil {
+			logger.Error("failed to read plugin config", "error", err, "fd", *configFD)
+			return
+		}
+		if !json.Valid(rawConfig) {
+			logger.Error("plugin config is not valid JSON", "fd", *configFD)
+			return
+		}
+		pluginConfig.raw = rawConfig
+	}
+
+	// The host pipe, if any, follows the config pipe.
+	var hostConn *grpc.ClientConn
+	if *hostFD >= 0 {
+		rawHostInfo, err := readSecretsFD(*hostFD)
+		if err != nil {
+			logger.Error("failed to read host info", "error", err, "fd", *hostFD)
+			return
+		}
+		hostConn, err = dialHost(rawHostInfo)
+		if err != nil {
+			logger.Error("failed to connect to host services", "error", err)
+			return
+		}
+		defer hostConn.Close()
+		logger.Debug("host services client created")
+	}
+
+	keyAndCert, err := transport.DeserializeKeyAndCert(rawKeyAndCert)
 	if err != nil {
 		logger.Error("failed to deserialize tls key and cert", "error", err)
 		return
 	}
 	logger.Debug("tls key and cert deserialized successfully")
 
-	lis, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(*port)))
+	network, address := "tcp", net.JoinHostPort("", strconv.Itoa(*port))
+	if *handshakeFD >= 0 {
+		// Started by a runner: listen on loopback only, on a port that
+		// cannot be taken by someone else in the meantime.
+		address = "127.0.0.1:0"
+	}
+	if *socketPath != "" {
+		network, address = "unix", *socketPath
+	}
+
+	lis, err := net.Listen(network, address)
 	if err != nil {
-		logger.Error("failed to listen", "error", err, "port", *port)
+		logger.Error("failed to listen", "error", err, "network", network, "address", address)
 		return
 	}
-	logger.Info("server listening", "port", *port)
+	address = lis.Addr().String()
+	logger.Info("server listening", "network", network, "address", address)
+
+	if *handshakeFD >= 0 {
+		handshake := &transport.Handshake{
+			Version:         transport.HandshakeVersion,
+			ProtocolVersion: options.ProtocolVersion,
+			Network:         network,
+			Address:         address,
+			CertFingerprint: keyAndCert.Fingerprint(),
+		}
+		if err := writeHandshakeFD(*handshakeFD, handshake); err != nil {
+			logger.Error("failed to write handshake", "error", err, "fd", *handshakeFD)
+			return
+		}
+		logger.Debug("handshake sent to runner")
+	}
 
 	tlsConfig, err := keyAndCert.GetTLSConfig()
 	if err != nil {
@@ -119,10 +266,28 @@ func StartPlugin(plugin Plugin) {
 
 	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
 
-	plugin.Start(PluginOptions{
+	pluginHealth := newHealth()
+	healthpb.RegisterHealthServer(s, pluginHealth.server)
+	metadata.RegisterMetadataServer(s, &metadataServer{
+		name:    *pluginName,
+		options: options,
+		server:  s,
+	})
+	pluginBroker := broker.NewPluginBroker(s, keyAndCert)
+
+	pluginOptions := PluginOptions{
 		Logger: logger,
 		Server: s,
-	})
+		Health: pluginHealth,
+		Config: pluginConfig,
+		Broker: pluginBroker,
+	}
+	// Assigned only when set, so plugins can compare Host to nil.
+	if hostConn != nil {
+		pluginOptions.Host = hostConn
+	}
+	plugin.Start(pluginOptions)
+	pluginHealth.markStarted()
 
 	// Start server in a goroutine
 	go func() {
@@ -138,6 +303,9 @@ func StartPlugin(plugin Plugin) {
 
 	// Initiate graceful shutdown
 	logger.Info("initiating graceful shutdown")
+	pluginHealth.server.Shutdown()
+	// Ends the broker stream, which would hold up the graceful stop.
+	pluginBroker.Close()
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer shutdownCancel()
 
@@ -0,0 +1,253 @@
+package buildcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cacheSubdir is appended to the user cache directory when no explicit
+	// cache directory is configured.
+	cacheSubdir = "grpc-plugin/builds"
+	// keyVersion is mixed into every key so a change in how keys are computed
+	// invalidates previously cached artifacts.
+	keyVersion = "v1"
+)
+
+// goEnvKeys are the toolchain settings that influence the produced binary.
+var goEnvKeys = []string{"GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS", "GOAMD64", "GOARM64"}
+
+type BuildCache struct {
+	dir string
+}
+
+// New returns a build cache rooted at dir. An empty dir selects a directory
+// below the user cache directory.
+func New(dir string) (*BuildCache, error) {
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to determine user cache directory")
+		}
+		dir = filepath.Join(userCacheDir, cacheSubdir)
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get absolute path for build cache %s", dir)
+	}
+
+	return &BuildCache{dir: absDir}, nil
+}
+
+// Dir returns the directory holding cached plugin binaries.
+func (c *BuildCache) Dir() string {
+	return c.dir
+}
+
+// Build returns the path of a binary built from the Go module at sourceDir,
+// compiling it only if no artifact exists for the current sources and
+// toolchain.
+func (c *BuildCache) Build(ctx context.Context, sourceDir string) (string, error) {
+	logger := slog.With("component", "build_cache", "source", sourceDir)
+
+	key, err := c.Key(ctx, sourceDir)
+	if err != nil {
+		logger.Error("failed to compute build key", "error", err)
+		return "", errors.Wrapf(err, "failed to compute build key for %s", sourceDir)
+	}
+
+	binaryPath := filepath.Join(c.dir, key)
+	if info, err := os.Stat(binaryPath); err == nil && info.Mode().IsRegular() {
+		logger.Debug("using cached plugin binary", "binary", binaryPath)
+		return binaryPath, nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		logger.Error("failed to create build cache directory", "error", err)
+		return "", errors.Wrapf(err, "failed to create build cache directory %s", c.dir)
+	}
+
+	mainPackage, err := findMainPackage(ctx, sourceDir)
+	if err != nil {
+		logger.Error("failed to find plugin main package", "error", err)
+		return "", err
+	}
+
+	tmpFile, err := os.CreateTemp(c.dir, key+".tmp-*")
+	if err != nil {
+		logger.Error("failed to create temporary build output", "error", err)
+		return "", errors.Wrap(err, "failed to create temporary build output")
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	logger.Info("building plugin", "binary", binaryPath)
+	cmd := exec.CommandContext(ctx, "/usr/bin/env", "go", "build", "-o", tmpPath, mainPackage)
+	cmd.Dir = sourceDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		logger.Error("failed to build plugin", "error", err)
+		return "", errors.Wrapf(err, "failed to build plugin at %s", sourceDir)
+	}
+
+	// Renaming is atomic, so concurrent builders of the same key never
+	// observe a partially written binary.
+	if err := os.Rename(tmpPath, binaryPath); err != nil {
+		logger.Error("failed to store plugin binary", "error", err)
+		return "", errors.Wrapf(err, "failed to store plugin binary at %s", binaryPath)
+	}
+
+	logger.Debug("plugin binary cached", "binary", binaryPath)
+	return binaryPath, nil
+}
+
+// findMainPackage returns the import path of the only main package of the
+// module at sourceDir. go build cannot write several packages to one file.
+func findMainPackage(ctx context.Context, sourceDir string) (string, error) {
+	listed, err := runGo(ctx, sourceDir, "list", "-f", `{{if eq .Name "main"}}{{.ImportPath}}{{end}}`, "./...")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list packages of plugin at %s", sourceDir)
+	}
+	mainPackages := strings.Fields(string(listed))
+	switch len(mainPackages) {
+	case 1:
+		return mainPackages[0], nil
+	case 0:
+		return "", errors.Errorf("plugin at %s has no main package", sourceDir)
+	default:
+		return "", errors.Errorf("plugin at %s has %d main packages, expected one: %s", sourceDir, len(mainPackages), strings.Join(mainPackages, ", "))
+	}
+}
+
+type listedModule struct {
+	Path    string
+	Version string
+	Sum     string
+	Replace *listedModule
+}
+
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *listedModule
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	HFiles     []string
+	SFiles     []string
+	SysoFiles  []string
+	EmbedFiles []string
+}
+
+// Key computes the content address of the plugin at sourceDir. It covers the
+// toolchain, the module's go.mod/go.sum, the source files of every package
+// built from local directories and the versions of all other dependencies.
+func (c *BuildCache) Key(ctx context.Context, sourceDir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "grpc-plugin build %s\n", keyVersion)
+
+	goEnv, err := runGo(ctx, sourceDir, append([]string{"env"}, goEnvKeys...)...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read go environment")
+	}
+	fmt.Fprintf(h, "env\n%s\n", goEnv)
+
+	for _, name := range []string{"go.mod", "go.sum"} {
+		if err := hashFile(h, filepath.Join(sourceDir, name)); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return "", err
+		}
+	}
+
+	listed, err := runGo(ctx, sourceDir, "list", "-deps", "-json", "./...")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list plugin packages")
+	}
+
+	var packages []listedPackage
+	decoder := json.NewDecoder(bytes.NewReader(listed))
+	for {
+		var pkg listedPackage
+		if err := decoder.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", errors.Wrap(err, "failed to decode go list output")
+		}
+		if !pkg.Standard {
+			packages = append(packages, pkg)
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool { return packages[i].ImportPath < packages[j].ImportPath })
+
+	for _, pkg := range packages {
+		module := pkg.Module
+		if module != nil && module.Replace != nil {
+			module = module.Replace
+		}
+
+		// Versioned modules are immutable, so their identity is enough.
+		if module != nil && module.Version != "" {
+			fmt.Fprintf(h, "module %s %s %s %s\n", pkg.ImportPath, module.Path, module.Version, module.Sum)
+			continue
+		}
+
+		fmt.Fprintf(h, "package %s\n", pkg.ImportPath)
+		files := make([]string, 0, len(pkg.GoFiles)+len(pkg.CgoFiles)+len(pkg.EmbedFiles))
+		for _, list := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles, pkg.EmbedFiles} {
+			files = append(files, list...)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			if err := hashFile(h, filepath.Join(pkg.Dir, file)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", path)
+	}
+	defer f.Close()
+
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, f); err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	fmt.Fprintf(h, "file %s %x\n", filepath.Base(path), fileHash.Sum(nil))
+	return nil
+}
+
+func runGo(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/usr/bin/env", append([]string{"go"}, args...)...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "go %s: %s", args[0], bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
@@ -0,0 +1,225 @@
+package buildcache
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// writeModule creates a minimal plugin module in a new directory.
+func writeModule(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module example.com/plugin\n\ngo 1.24\n",
+		"main.go":      "package main\n\nimport \"example.com/plugin/lib\"\n\nfunc main() { lib.Run() }\n",
+		"lib/lib.go":   "package lib\n\nfunc Run() {}\n",
+		"README.md":    "# plugin\n",
+		"lib/data.txt": "data\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestKey(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, dir string)
+		changed bool
+	}{
+		{
+			name:    "unchanged",
+			modify:  func(t *testing.T, dir string) {},
+			changed: false,
+		},
+		{
+			name: "main package source",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "main.go"), "package main\n\nimport \"example.com/plugin/lib\"\n\nfunc main() { lib.Run(); lib.Run() }\n")
+			},
+			changed: true,
+		},
+		{
+			name: "dependency package source",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "lib", "lib.go"), "package lib\n\nfunc Run() { println() }\n")
+			},
+			changed: true,
+		},
+		{
+			name: "new source file",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "lib", "extra.go"), "package lib\n\nvar extra = 1\n")
+			},
+			changed: true,
+		},
+		{
+			name: "go.mod",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/plugin\n\ngo 1.24.0\n")
+			},
+			changed: true,
+		},
+		{
+			name: "file outside the build",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "README.md"), "# changed\n")
+			},
+			changed: false,
+		},
+		{
+			name: "file not embedded",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "lib", "data.txt"), "changed\n")
+			},
+			changed: false,
+		},
+	}
+
+	ctx := context.Background()
+	cache := &BuildCache{dir: t.TempDir()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t)
+			before, err := cache.Key(ctx, dir)
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			tt.modify(t, dir)
+			after, err := cache.Key(ctx, dir)
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			if changed := before != after; changed != tt.changed {
+				t.Errorf("key changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestKeyIndependentOfLocation(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	ctx := context.Background()
+	cache := &BuildCache{dir: t.TempDir()}
+	first, err := cache.Key(ctx, writeModule(t))
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	second, err := cache.Key(ctx, writeModule(t))
+	if err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+	if first != second {
+		t.Errorf("identical modules in different directories got different keys %s and %s", first, second)
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	ctx := context.Background()
+	cache := &BuildCache{dir: t.TempDir()}
+	dir := writeModule(t)
+
+	binary, err := cache.Build(ctx, dir)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := exec.Command(binary).Run(); err != nil {
+		t.Fatalf("built binary failed to run: %v", err)
+	}
+	info, err := os.Stat(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := cache.Build(ctx, dir)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if cached != binary {
+		t.Errorf("second Build() = %s, want cached %s", cached, binary)
+	}
+	if cachedInfo, err := os.Stat(cached); err != nil || !cachedInfo.ModTime().Equal(info.ModTime()) {
+		t.Errorf("second Build() rebuilt the binary")
+	}
+}
+
+func TestBuildMainPackages(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(t *testing.T, dir string)
+		wantErr bool
+	}{
+		{
+			name: "main package in a subdirectory",
+			modify: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "main.go")); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(dir, "cmd", "plugin", "main.go"), "package main\n\nimport \"example.com/plugin/lib\"\n\nfunc main() { lib.Run() }\n")
+			},
+		},
+		{
+			name: "several main packages",
+			modify: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "cmd", "tool", "main.go"), "package main\n\nfunc main() {}\n")
+			},
+			wantErr: true,
+		},
+		{
+			name: "no main package",
+			modify: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, "main.go")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeModule(t)
+			tt.modify(t, dir)
+			cache := &BuildCache{dir: t.TempDir()}
+			if _, err := cache.Build(ctx, dir); (err != nil) != tt.wantErr {
+				t.Errorf("Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package pluginrunner
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pluginConn is a grpc.ClientConnInterface forwarding every call to the
+// connection of the plugin's current instance. Clients generated on top of it
+// keep working when the plugin is restarted or upgraded.
+type pluginConn struct {
+	name     string
+	mu       sync.RWMutex
+	instance *connInstance
+	closed   bool
+}
+
+// connInstance is the connection to one plugin instance together with the
+// RPCs in flight on it.
+type connInstance struct {
+	conn     *grpc.ClientConn
+	inflight sync.WaitGroup
+}
+
+func newPluginConn(name string, conn *grpc.ClientConn) *pluginConn {
+	return &pluginConn{
+		name:     name,
+		instance: &connInstance{conn: conn},
+	}
+}
+
+// drain waits until the RPCs in flight on the instance have finished or ctx
+// is done and then closes its connection. The instance must no longer be
+// current, so no new RPCs start on it.
+func (i *connInstance) drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		i.inflight.Wait()
+		close(drained)
+	}()
+
+	var drainErr error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		drainErr = errors.Wrap(ctx.Err(), "in-flight RPCs did not finish")
+	}
+
+	if err := i.conn.Close(); err != nil {
+		return errors.Wrap(err, "failed to close connection")
+	}
+	return drainErr
+}
+
+// begin returns the current instance and registers an in-flight RPC on it,
+// which the caller must end with inflight.Done.
+func (c *pluginConn) begin() (*connInstance, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return nil, status.Errorf(codes.Unavailable, "plugin %s is closed", c.name)
+	}
+	if c.instance == nil {
+		return nil, status.Errorf(codes.Unavailable, "plugin %s is not running", c.name)
+	}
+	c.instance.inflight.Add(1)
+	return c.instance, nil
+}
+
+// swap routes new RPCs to conn and returns the previous instance, if any. A
+// nil conn makes calls fail with codes.Unavailable until the next swap. Once
+// the pluginConn is closed, conn is closed right away instead.
+func (c *pluginConn) swap(conn *grpc.ClientConn) *connInstance {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil
+	}
+	old := c.instance
+	c.instance = nil
+	if conn != nil {
+		c.instance = &connInstance{conn: conn}
+	}
+	return old
+}
+
+// shutdown rejects new RPCs, waits until in-flight RPCs have finished or ctx
+// is done and then closes the underlying connection.
+func (c *pluginConn) shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	instance := c.instance
+	c.instance = nil
+	c.mu.Unlock()
+
+	if instance == nil {
+		return nil
+	}
+	return instance.drain(ctx)
+}
+
+func (c *pluginConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	instance, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer instance.inflight.Done()
+	return instance.conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *pluginConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	instance, err := c.begin()
+	if err != nil {
+		return nil, err
+	}
+	// A stream stays in flight until it finished, which grpc reports through
+	// OnFinish.
+	done := sync.OnceFunc(instance.inflight.Done)
+	opts = append(opts, grpc.OnFinish(func(error) { done() }))
+	stream, err := instance.conn.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	return stream, nil
+}
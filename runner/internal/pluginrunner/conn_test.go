@@ -0,0 +1,220 @@
+package pluginrunner
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var testStreamDesc = &grpc.StreamDesc{StreamName: "Call", ServerStreams: true, ClientStreams: true}
+
+// testServer answers every method once release is closed, after reporting
+// the call on entered.
+type testServer struct {
+	listener *bufconn.Listener
+	entered  chan struct{}
+	release  chan struct{}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{
+		listener: bufconn.Listen(1 << 16),
+		entered:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error {
+		if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			return err
+		}
+		s.entered <- struct{}{}
+		<-s.release
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go server.Serve(s.listener)
+	t.Cleanup(server.Stop)
+	return s
+}
+
+func (s *testServer) dial(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.NewClient("passthrough:///test",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// callUnary makes a unary call on conn.
+func callUnary(ctx context.Context, conn grpc.ClientConnInterface) error {
+	return conn.Invoke(ctx, "/test.Test/Call", &emptypb.Empty{}, &emptypb.Empty{})
+}
+
+// callStream makes a streaming call on conn and reads it to the end, like
+// generated clients do.
+func callStream(ctx context.Context, conn grpc.ClientConnInterface) error {
+	stream, err := conn.NewStream(ctx, testStreamDesc, "/test.Test/Call")
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&emptypb.Empty{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		if err := stream.RecvMsg(&emptypb.Empty{}); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestPluginConnDrainWaitsForInFlightRPCs(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(ctx context.Context, conn grpc.ClientConnInterface) error
+	}{
+		{name: "unary", call: callUnary},
+		{name: "stream", call: callStream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			conn := server.dial(t)
+			c := newPluginConn("test", conn)
+
+			called := make(chan error, 1)
+			go func() {
+				called <- tt.call(context.Background(), c)
+			}()
+			<-server.entered
+
+			old := c.swap(server.dial(t))
+			drained := make(chan error, 1)
+			go func() {
+				drained <- old.drain(context.Background())
+			}()
+
+			select {
+			case err := <-drained:
+				t.Fatalf("drain() returned while an RPC was in flight, error = %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(server.release)
+			if err := <-called; err != nil {
+				t.Errorf("in-flight RPC error = %v", err)
+			}
+			select {
+			case err := <-drained:
+				if err != nil {
+					t.Errorf("drain() error = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("drain() did not return once the RPC finished")
+			}
+			if state := conn.GetState(); state != connectivity.Shutdown {
+				t.Errorf("drained connection is %s, want %s", state, connectivity.Shutdown)
+			}
+
+			// New RPCs go to the connection swapped in.
+			if err := tt.call(context.Background(), c); err != nil {
+				t.Errorf("RPC after swap error = %v", err)
+			}
+		})
+	}
+}
+
+func TestPluginConnDrainTimeout(t *testing.T) {
+	server := newTestServer(t)
+	conn := server.dial(t)
+	c := newPluginConn("test", conn)
+	defer close(server.release)
+
+	go callUnary(context.Background(), c)
+	<-server.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.shutdown(ctx); err == nil {
+		t.Error("shutdown() returned no error while an RPC was in flight")
+	}
+	if state := conn.GetState(); state != connectivity.Shutdown {
+		t.Errorf("connection is %s after shutdown, want %s", state, connectivity.Shutdown)
+	}
+}
+
+func TestPluginConnUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		stop func(c *pluginConn)
+	}{
+		{
+			name: "not running",
+			stop: func(c *pluginConn) {
+				c.swap(nil).drain(context.Background())
+			},
+		},
+		{
+			name: "shut down",
+			stop: func(c *pluginConn) {
+				c.shutdown(context.Background())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			c := newPluginConn("test", server.dial(t))
+			tt.stop(c)
+
+			if err := callUnary(context.Background(), c); status.Code(err) != codes.Unavailable {
+				t.Errorf("unary RPC error = %v, want code %s", err, codes.Unavailable)
+			}
+			if err := callStream(context.Background(), c); status.Code(err) != codes.Unavailable {
+				t.Errorf("streaming RPC error = %v, want code %s", err, codes.Unavailable)
+			}
+		})
+	}
+}
+
+func TestPluginConnSwapAfterShutdown(t *testing.T) {
+	server := newTestServer(t)
+	c := newPluginConn("test", server.dial(t))
+	if err := c.shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	conn := server.dial(t)
+	if old := c.swap(conn); old != nil {
+		t.Error("swap() on a closed connection returned an instance")
+	}
+	if state := conn.GetState(); state != connectivity.Shutdown {
+		t.Errorf("connection swapped into a closed pluginConn is %s, want %s", state, connectivity.Shutdown)
+	}
+	if err := callUnary(context.Background(), c); status.Code(err) != codes.Unavailable {
+		t.Errorf("unary RPC error = %v, want code %s", err, codes.Unavailable)
+	}
+}
@@ -0,0 +1,66 @@
+package pluginrunner
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+)
+
+// maxHandshakeSize bounds the handshake line, a unix socket path is the
+// longest part of it.
+const maxHandshakeSize = 4096
+
+// readHandshake waits for the plugin to report where it listens and records
+// the address. It fails if the plugin exits first, reports another network or
+// certificate than it was started with, or ctx is done.
+func (s *PluginServerConf) readHandshake(ctx context.Context) error {
+	defer s.handshake.Close()
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(io.LimitReader(s.handshake, maxHandshakeSize)).ReadBytes('\n')
+		read <- result{line: line, err: err}
+	}()
+
+	var r result
+	select {
+	case r = <-read:
+	case <-ctx.Done():
+		return errors.Wrap(context.Cause(ctx), "plugin did not complete the handshake")
+	}
+
+	if r.err != nil {
+		// The pipe is closed when the plugin exits, which is reaped shortly
+		// after.
+		select {
+		case <-s.exited:
+			return errors.Errorf("plugin exited before completing the handshake: %v", s.exitErr)
+		case <-time.After(readinessPollInterval):
+		}
+		return errors.Wrap(r.err, "failed to read handshake")
+	}
+
+	handshake, err := transport.ParseHandshake(r.line)
+	if err != nil {
+		return err
+	}
+	if handshake.Network != s.network {
+		return errors.Errorf("plugin listens on network %q, expected %q", handshake.Network, s.network)
+	}
+	if handshake.CertFingerprint != s.certFingerprint {
+		return errors.New("plugin serves a different certificate than it was issued")
+	}
+
+	s.Network = handshake.Network
+	s.Address = handshake.Address
+	s.ProtocolVersion = handshake.ProtocolVersion
+	return nil
+}
@@ -0,0 +1,179 @@
+package pluginrunner
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// HostServer serves the host services of config.Config.HostServices to the
+// plugins over mTLS. Every plugin instance gets a client certificate of its
+// own, calls presenting any other certificate are refused.
+type HostServer struct {
+	server   *grpc.Server
+	listener net.Listener
+	logger   *slog.Logger
+
+	transportGenerator *transport.TransportGenerator
+
+	mu sync.RWMutex
+	// peers maps the fingerprints of the issued client certificates to the
+	// names of the plugins they were issued to.
+	peers map[string]string
+}
+
+type callingPluginKey struct{}
+
+// CallingPlugin returns the name of the plugin that made the call handled
+// with ctx. It is only set in host services.
+func CallingPlugin(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(callingPluginKey{}).(string)
+	return name, ok
+}
+
+// NewHostServer starts serving the services registered by register on a
+// loopback port picked by the OS.
+func NewHostServer(register func(grpc.ServiceRegistrar), transportGenerator *transport.TransportGenerator) (*HostServer, error) {
+	logger := slog.With("component", "host_server")
+
+	keyAndCert, err := transportGenerator.GenerateKeyAndCert("host", transport.RoleServer)
+	if err != nil {
+		logger.Error("failed to generate host key and cert", "error", err)
+		return nil, errors.Wrap(err, "failed to generate host key and cert")
+	}
+	tlsConfig, err := keyAndCert.GetTLSConfig()
+	if err != nil {
+		logger.Error("failed to get host TLS config", "error", err)
+		return nil, errors.Wrap(err, "failed to get host TLS config")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		logger.Error("failed to listen", "error", err)
+		return nil, errors.Wrap(err, "failed to listen for host services")
+	}
+
+	h := &HostServer{
+		listener:           listener,
+		logger:             logger.With("address", listener.Addr().String()),
+		transportGenerator: transportGenerator,
+		peers:              make(map[string]string),
+	}
+	h.server = grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, err := h.authorize(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := h.authorize(stream.Context())
+			if err != nil {
+				return err
+			}
+			return handler(srv, &hostServerStream{ServerStream: stream, ctx: ctx})
+		}),
+	)
+	register(h.server)
+
+	go func() {
+		if err := h.server.Serve(listener); err != nil {
+			h.logger.Error("host server stopped", "error", err)
+		}
+	}()
+
+	h.logger.Info("serving host services")
+	return h, nil
+}
+
+// hostServerStream carries the context returned by authorize.
+type hostServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *hostServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize looks up the plugin the peer's certificate was issued to and
+// records it in the returned context.
+func (h *HostServer) authorize(ctx context.Context) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	fingerprint := (&transport.KeyAndCert{Cert: tlsInfo.State.PeerCertificates[0]}).Fingerprint()
+
+	h.mu.RLock()
+	name, ok := h.peers[fingerprint]
+	h.mu.RUnlock()
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "client certificate was not issued to a running plugin")
+	}
+	return context.WithValue(ctx, callingPluginKey{}, name), nil
+}
+
+// issue creates the HostInfo for an instance of the named plugin. The client
+// certificate in it is accepted until revoke is called.
+func (h *HostServer) issue(name string) (info []byte, revoke func(), err error) {
+	keyAndCert, err := h.transportGenerator.GenerateKeyAndCert(name+"_host", transport.RoleClient)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate host client key and cert")
+	}
+	serialized, err := keyAndCert.Serialize()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to serialize host client key and cert")
+	}
+	info, err = json.Marshal(transport.HostInfo{
+		Network:    "tcp",
+		Address:    h.listener.Addr().String(),
+		KeyAndCert: serialized,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode host info")
+	}
+
+	fingerprint := keyAndCert.Fingerprint()
+	h.mu.Lock()
+	h.peers[fingerprint] = name
+	h.mu.Unlock()
+
+	revoke = func() {
+		h.mu.Lock()
+		delete(h.peers, fingerprint)
+		h.mu.Unlock()
+	}
+	return info, revoke, nil
+}
+
+// Stop stops serving. Calls in flight may finish until ctx is done.
+func (h *HostServer) Stop(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		h.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		h.server.Stop()
+	}
+	h.logger.Debug("host server stopped")
+}
@@ -0,0 +1,454 @@
+package pluginrunner
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/broker"
+	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"github.com/trustdsh/grpc-plugin/pkgs/metadata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// restartResetAfter is how long an instance has to run before its exit
+	// no longer counts as a consecutive restart.
+	restartResetAfter = time.Minute
+)
+
+// LoadedPlugin is a running plugin together with the typed client used to
+// call it. Plugin stays valid across restarts of the plugin process.
+type LoadedPlugin[T any] struct {
+	Plugin T
+
+	ctx          context.Context
+	pluginConfig config.ManifestPlugin
+	cfg          *config.Config[T]
+	resources    *Resources
+	conn         *pluginConn
+	logger       *slog.Logger
+
+	mu          sync.Mutex
+	server      *PluginServerConf
+	closed      bool
+	closing     chan struct{}
+	restarts    int
+	lastExitErr error
+	state       State
+	lastErr     error
+
+	// protocolVersion is the protocol version Plugin was generated for.
+	protocolVersion int
+}
+
+// Server returns the plugin's current instance.
+func (l *LoadedPlugin[T]) Server() *PluginServerConf {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.server
+}
+
+// Conn returns the connection the plugin's client was generated from. It
+// follows the plugin across restarts and is closed together with the plugin.
+func (l *LoadedPlugin[T]) Conn() grpc.ClientConnInterface {
+	return l.conn
+}
+
+// Config returns the manifest entry the plugin was loaded from.
+func (l *LoadedPlugin[T]) Config() config.ManifestPlugin {
+	return l.pluginConfig
+}
+
+// Describe queries the plugin's metadata service.
+func (l *LoadedPlugin[T]) Describe(ctx context.Context) (*metadata.DescribeResponse, error) {
+	response, err := metadata.NewMetadataClient(l.conn).Describe(ctx, &metadata.DescribeRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, errors.Errorf("plugin %s does not implement the metadata service, rebuild it against a newer version of this library", l.pluginConfig.GetName())
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to describe plugin %s", l.pluginConfig.GetName())
+	}
+	return response, nil
+}
+
+// Broker returns the runner's side of the broker of the plugin's current
+// instance. Restarts and upgrades replace the instance together with its
+// broker, Done of the old broker is closed then.
+func (l *LoadedPlugin[T]) Broker() *broker.Broker {
+	return l.Server().broker
+}
+
+// Restarts returns how often the plugin process was restarted.
+func (l *LoadedPlugin[T]) Restarts() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.restarts
+}
+
+// LastExitErr returns how the last unexpected exit of the plugin process
+// ended: nil for none or a clean exit, an *exec.ExitError otherwise.
+func (l *LoadedPlugin[T]) LastExitErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastExitErr
+}
+
+// startInstance starts a new plugin process that runs until ctx is done and
+// connects to it. startCtx bounds how long to wait for it to become ready, in
+// addition to the plugin's startup timeout.
+func startInstance[T any](ctx, startCtx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], resources *Resources) (*PluginServerConf, *grpc.ClientConn, error) {
+	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
+
+	// The client certificate is issued first so the plugin can refuse
+	// clients presenting any other certificate, such as the ones other
+	// plugins call host services with. The broker dials the plugin's
+	// sub-connections with the same certificate.
+	clientKeyAndCert, err := resources.TransportGenerator.KeyAndCertForPlugin(pluginConfig, transport.RoleClient)
+	if err != nil {
+		logger.Error("failed to generate client key and cert", "error", err)
+		return nil, nil, errors.Wrapf(err, "failed to generate client key and cert for plugin %s", pluginConfig.GetName())
+	}
+
+	pluginServer, err := startPluginServer(ctx, pluginConfig, cfg, resources, clientKeyAndCert.Fingerprint())
+	if err != nil {
+		logger.Error("failed to start plugin server", "error", err)
+		return nil, nil, errors.Wrapf(err, "failed to start server for plugin %s", pluginConfig.GetName())
+	}
+
+	timeout := startupTimeout
+	if pluginConfig.StartupTimeout > 0 {
+		timeout = pluginConfig.StartupTimeout
+	}
+	startCtx, cancel := context.WithTimeoutCause(startCtx, timeout, errors.Errorf("startup timeout of %v elapsed", timeout))
+	defer cancel()
+
+	if err := pluginServer.readHandshake(startCtx); err != nil {
+		logger.Error("plugin handshake failed", "error", err)
+		if closeErr := pluginServer.terminate(context.Background(), logger, resources.ShutdownGracePeriod); closeErr != nil {
+			logger.Error("failed to stop plugin process after handshake error", "error", closeErr)
+		}
+		return nil, nil, errors.Wrapf(err, "handshake with plugin %s failed", pluginConfig.GetName())
+	}
+	logger.Debug("plugin handshake completed", "network", pluginServer.Network, "address", pluginServer.Address, "protocol_version", pluginServer.ProtocolVersion)
+
+	if _, err := cfg.NegotiateProtocol(pluginServer.ProtocolVersion); err != nil {
+		logger.Error("plugin is incompatible", "error", err)
+		if closeErr := pluginServer.terminate(context.Background(), logger, resources.ShutdownGracePeriod); closeErr != nil {
+			logger.Error("failed to stop plugin process after protocol error", "error", closeErr)
+		}
+		return nil, nil, errors.Wrapf(err, "plugin %s is incompatible", pluginConfig.GetName())
+	}
+
+	conn, err := dialPlugin(pluginServer, pluginConfig, clientKeyAndCert)
+	if err != nil {
+		logger.Error("failed to create plugin client", "error", err)
+		if closeErr := pluginServer.terminate(context.Background(), logger, resources.ShutdownGracePeriod); closeErr != nil {
+			logger.Error("failed to stop plugin process after client creation error", "error", closeErr)
+		}
+		return nil, nil, errors.Wrapf(err, "failed to create client for plugin %s", pluginConfig.GetName())
+	}
+
+	if err := waitForServing(startCtx, pluginConfig, pluginServer, conn); err != nil {
+		logger.Error("plugin did not become ready", "error", err)
+		conn.Close()
+		if closeErr := pluginServer.terminate(context.Background(), logger, resources.ShutdownGracePeriod); closeErr != nil {
+			logger.Error("failed to stop plugin process after readiness error", "error", closeErr)
+		}
+		return nil, nil, errors.Wrapf(err, "plugin %s did not become ready", pluginConfig.GetName())
+	}
+
+	// The broker stream runs on the instance's connection and ends with it.
+	var issue func(subject string, role transport.Role) (*transport.KeyAndCert, error)
+	if resources.TLS.CanIssue() {
+		issue = resources.TransportGenerator.GenerateKeyAndCert
+	}
+	pluginServer.broker, err = broker.NewHostBroker(ctx, pluginConfig.GetName(), conn, clientKeyAndCert, issue, &resources.brokerIDs)
+	if err != nil {
+		logger.Error("failed to open broker stream", "error", err)
+		conn.Close()
+		if closeErr := pluginServer.terminate(context.Background(), logger, resources.ShutdownGracePeriod); closeErr != nil {
+			logger.Error("failed to stop plugin process after broker error", "error", closeErr)
+		}
+		return nil, nil, errors.Wrapf(err, "failed to open broker of plugin %s", pluginConfig.GetName())
+	}
+
+	return pluginServer, conn, nil
+}
+
+// NewPlugin returns the plugin described by pluginConfig in StateStarting.
+// It runs on ctx once Start succeeds.
+func NewPlugin[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], resources *Resources) *LoadedPlugin[T] {
+	return &LoadedPlugin[T]{
+		ctx:          ctx,
+		pluginConfig: pluginConfig,
+		cfg:          cfg,
+		resources:    resources,
+		logger:       slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName()),
+		closing:      make(chan struct{}),
+		state:        StateStarting,
+	}
+}
+
+// Start starts the first instance of a plugin returned by NewPlugin and
+// waits until it is ready. The plugin is in StateReady afterwards, or in
+// StateStopped with the error recorded if it failed to start.
+func (l *LoadedPlugin[T]) Start() error {
+	l.logger.Info("loading plugin")
+
+	pluginServer, conn, err := startInstance(l.ctx, l.ctx, l.pluginConfig, l.cfg, l.resources)
+	if err != nil {
+		l.setState(StateStopped, err)
+		return err
+	}
+	// startInstance refuses plugins whose protocol version is not supported.
+	generator, _ := l.cfg.NegotiateProtocol(pluginServer.ProtocolVersion)
+
+	l.mu.Lock()
+	l.conn = newPluginConn(l.pluginConfig.GetName(), conn)
+	l.server = pluginServer
+	l.protocolVersion = pluginServer.ProtocolVersion
+	l.Plugin = generator(l.conn)
+	l.setStateLocked(StateReady, nil)
+	l.mu.Unlock()
+
+	go l.supervise()
+	go l.watchHealth(pluginServer, conn)
+
+	l.logger.Info("plugin loaded successfully")
+	return nil
+}
+
+// supervise waits for the plugin process to exit and, unless the plugin was
+// closed, restarts it according to its restart policy.
+func (l *LoadedPlugin[T]) supervise() {
+	attempts := 0
+	for {
+		server := l.Server()
+		<-server.Exited()
+		exitErr := server.ExitErr()
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return
+		}
+		if l.server != server {
+			// The instance was replaced by Upgrade, follow the new one.
+			l.mu.Unlock()
+			continue
+		}
+		l.lastExitErr = exitErr
+		if exitErr != nil {
+			l.lastErr = errors.Wrap(exitErr, "plugin process exited")
+		} else {
+			l.lastErr = errors.New("plugin process exited with status 0")
+		}
+		l.mu.Unlock()
+
+		l.logger.Warn("plugin process exited unexpectedly", "pid", server.Process.Pid, "error", exitErr)
+		if instance := l.conn.swap(nil); instance != nil {
+			instance.conn.Close()
+		}
+
+		if time.Since(server.StartedAt) >= restartResetAfter {
+			attempts = 0
+		}
+
+		for restarted := false; !restarted; {
+			if !l.pluginConfig.Restart.ShouldRestart(exitErr, attempts) {
+				l.setState(StateStopped, nil)
+				l.logger.Error("plugin stopped and will not be restarted", "restarts", attempts)
+				return
+			}
+			l.setState(StateRestarting, nil)
+
+			backoff := l.pluginConfig.Restart.Backoff(attempts)
+			attempts++
+			l.logger.Info("restarting plugin", "attempt", attempts, "backoff", backoff)
+
+			select {
+			case <-time.After(backoff):
+			case <-l.closing:
+				return
+			case <-l.ctx.Done():
+				return
+			}
+
+			newServer, conn, err := startInstance(l.ctx, l.ctx, l.pluginConfig, l.cfg, l.resources)
+			if err == nil {
+				if err = l.checkProtocolVersion(newServer); err != nil {
+					conn.Close()
+					if stopErr := l.terminate(l.ctx, newServer); stopErr != nil {
+						l.logger.Warn("failed to stop restarted plugin", "error", stopErr)
+					}
+				}
+			}
+			if err != nil {
+				l.logger.Error("failed to restart plugin", "attempt", attempts, "error", err)
+				l.setState(StateRestarting, err)
+				exitErr = err
+				continue
+			}
+
+			l.mu.Lock()
+			if l.closed {
+				l.mu.Unlock()
+				conn.Close()
+				if err := l.terminate(context.Background(), newServer); err != nil {
+					l.logger.Error("failed to stop restarted plugin after close", "error", err)
+				}
+				return
+			}
+			l.server = newServer
+			l.restarts++
+			l.setStateLocked(StateReady, nil)
+			l.conn.swap(conn)
+			l.mu.Unlock()
+
+			go l.watchHealth(newServer, conn)
+			restarted = true
+			l.logger.Info("plugin restarted", "attempt", attempts, "pid", newServer.Process.Pid)
+		}
+	}
+}
+
+// Upgrade replaces the running instance of the plugin without downtime. A
+// new instance is started next to the current one and, once it reports
+// SERVING, new RPCs are routed to it. RPCs in flight on the old instance get
+// up to the shutdown grace period to finish before the old instance is shut
+// down like CloseContext does. ctx bounds waiting for the new instance to
+// become ready and for the old one to exit.
+func (l *LoadedPlugin[T]) Upgrade(ctx context.Context) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return errors.Errorf("plugin %s is closed", l.pluginConfig.GetName())
+	}
+	if l.state != StateReady && l.state != StateUnhealthy {
+		state := l.state
+		l.mu.Unlock()
+		return errors.Errorf("plugin %s cannot be upgraded while %s", l.pluginConfig.GetName(), state)
+	}
+	l.mu.Unlock()
+
+	l.logger.Info("upgrading plugin")
+	newServer, conn, err := startInstance(l.ctx, ctx, l.pluginConfig, l.cfg, l.resources)
+	if err != nil {
+		l.logger.Error("failed to start new plugin instance", "error", err)
+		return errors.Wrapf(err, "failed to upgrade plugin %s", l.pluginConfig.GetName())
+	}
+	if err := l.checkProtocolVersion(newServer); err != nil {
+		l.logger.Error("new plugin instance is incompatible", "error", err)
+		conn.Close()
+		if stopErr := l.terminate(ctx, newServer); stopErr != nil {
+			l.logger.Warn("failed to stop new plugin instance", "error", stopErr)
+		}
+		return errors.Wrapf(err, "failed to upgrade plugin %s", l.pluginConfig.GetName())
+	}
+
+	l.mu.Lock()
+	oldServer := l.server
+	var abortErr error
+	select {
+	case <-oldServer.Exited():
+		// supervise restarts the plugin on its own, the new instance would
+		// race with it.
+		abortErr = errors.Errorf("plugin %s exited during upgrade", l.pluginConfig.GetName())
+	default:
+	}
+	if l.closed {
+		abortErr = errors.Errorf("plugin %s was closed during upgrade", l.pluginConfig.GetName())
+	}
+	if abortErr != nil {
+		l.mu.Unlock()
+		conn.Close()
+		if err := l.terminate(ctx, newServer); err != nil {
+			l.logger.Warn("failed to stop new plugin instance", "error", err)
+		}
+		return abortErr
+	}
+	l.server = newServer
+	l.setStateLocked(StateReady, nil)
+	oldInstance := l.conn.swap(conn)
+	l.mu.Unlock()
+
+	go l.watchHealth(newServer, conn)
+	l.logger.Info("switched to new plugin instance", "pid", newServer.Process.Pid, "old_pid", oldServer.Process.Pid)
+
+	if oldInstance != nil {
+		drainCtx, cancel := context.WithTimeout(ctx, l.resources.ShutdownGracePeriod)
+		err := oldInstance.drain(drainCtx)
+		cancel()
+		if err != nil {
+			l.logger.Warn("failed to drain old plugin instance", "error", err)
+		}
+	}
+
+	if err := l.terminate(ctx, oldServer); err != nil {
+		return errors.Wrap(err, "plugin upgraded but old instance did not stop cleanly")
+	}
+
+	l.logger.Info("plugin upgraded successfully")
+	return nil
+}
+
+// ProtocolVersion returns the protocol version the plugin declared when it
+// was loaded. Restarted and upgraded instances must declare the same one.
+func (l *LoadedPlugin[T]) ProtocolVersion() int {
+	return l.protocolVersion
+}
+
+// checkProtocolVersion refuses a new instance of the plugin that speaks
+// another protocol version than the plugin was loaded with, the generated
+// client would not match it.
+func (l *LoadedPlugin[T]) checkProtocolVersion(server *PluginServerConf) error {
+	if server.ProtocolVersion == l.protocolVersion {
+		return nil
+	}
+	return errors.Errorf("plugin %s now speaks protocol version %d instead of %d, reload it to switch versions", l.pluginConfig.GetName(), server.ProtocolVersion, l.protocolVersion)
+}
+
+// Close stops the plugin, see CloseContext.
+func (l *LoadedPlugin[T]) Close() error {
+	return l.CloseContext(context.Background())
+}
+
+// CloseContext shuts the plugin down. New RPCs are rejected with
+// codes.Unavailable right away, in-flight RPCs get up to the shutdown grace
+// period to finish before the connection is closed. Then SIGTERM is sent to
+// the plugin's process group. If the process is still running once the grace
+// period has passed again or ctx is done, the group is killed with SIGKILL.
+// CloseContext returns once the process has been reaped.
+func (l *LoadedPlugin[T]) CloseContext(ctx context.Context) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.closing)
+	server := l.server
+	l.setStateLocked(StateStopped, nil)
+	l.mu.Unlock()
+
+	drainCtx, cancel := context.WithTimeout(ctx, l.resources.ShutdownGracePeriod)
+	err := l.conn.shutdown(drainCtx)
+	cancel()
+	if err != nil {
+		l.logger.Warn("failed to shut down plugin connection cleanly", "pid", server.Process.Pid, "error", err)
+	}
+
+	return l.terminate(ctx, server)
+}
+
+// terminate stops server like PluginServerConf.terminate with the plugin's
+// shutdown grace period.
+func (l *LoadedPlugin[T]) terminate(ctx context.Context, server *PluginServerConf) error {
+	return server.terminate(ctx, l.logger, l.resources.ShutdownGracePeriod)
+}
@@ -2,46 +2,179 @@ package pluginrunner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/internal/broker"
 	"github.com/trustdsh/grpc-plugin/internal/transport"
 	"github.com/trustdsh/grpc-plugin/pkgs/config"
-	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner/portmanager"
+	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner/buildcache"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
 const (
 	startupTimeout = 10 * time.Second
+	// firstPipeFD is the descriptor number the first entry of exec.Cmd's
+	// ExtraFiles gets in the plugin process.
+	firstPipeFD = 3
 )
 
-type LoadedPlugin[T any] struct {
-	Plugin T
-	Server *PluginServerConf
+type PluginServerConf struct {
+	// Network and Address locate the plugin's gRPC server, e.g. "tcp" and
+	// "127.0.0.1:41234" or "unix" and a socket path. Both are reported by the
+	// plugin in its handshake.
+	Network string
+	Address string
+	// ProtocolVersion is the protocol version the plugin declared in its
+	// handshake, 0 for none.
+	ProtocolVersion int
+	Process         *os.Process
+	// StartedAt is when the plugin process was started.
+	StartedAt time.Time
+
+	cmd     *exec.Cmd
+	exited  chan struct{}
+	exitErr error
+	// handshake is the read end of the pipe the plugin writes its handshake
+	// to, see readHandshake.
+	handshake *os.File
+	// network and certFingerprint are what the handshake must report.
+	network         string
+	certFingerprint string
+	// revokeHost revokes the host client certificate of the instance, nil
+	// if it got none.
+	revokeHost func()
+	// broker is the runner's side of the instance's broker, set once the
+	// instance is ready.
+	broker *broker.Broker
 }
 
-type PluginServerConf struct {
-	Port    int
-	Process *os.Process
+// wait reaps the plugin process and records how it exited.
+func (s *PluginServerConf) wait() {
+	s.exitErr = s.cmd.Wait()
+	if s.revokeHost != nil {
+		s.revokeHost()
+	}
+	close(s.exited)
+}
+
+// Exited is closed once the plugin process has exited and was reaped.
+func (s *PluginServerConf) Exited() <-chan struct{} {
+	return s.exited
+}
+
+// ExitErr returns nil if the process exited with status 0 and an
+// *exec.ExitError otherwise. Only valid once Exited is closed.
+func (s *PluginServerConf) ExitErr() error {
+	return s.exitErr
+}
+
+// ExitCode returns the exit code of the process, or -1 if it was terminated
+// by a signal. Only valid once Exited is closed.
+func (s *PluginServerConf) ExitCode() int {
+	return s.cmd.ProcessState.ExitCode()
+}
+
+// signal sends sig to the plugin's process group unless it already exited.
+func (s *PluginServerConf) signal(sig syscall.Signal) error {
+	select {
+	case <-s.exited:
+		return nil
+	default:
+	}
+	return syscall.Kill(-s.Process.Pid, sig)
+}
+
+// terminate sends SIGTERM to the process group of the plugin and kills it if
+// it did not exit within grace or before ctx is done. It returns once the
+// process was reaped.
+func (s *PluginServerConf) terminate(ctx context.Context, logger *slog.Logger, grace time.Duration) error {
+	logger = logger.With("pid", s.Process.Pid)
+
+	if err := s.signal(syscall.SIGTERM); err != nil {
+		logger.Warn("failed to send SIGTERM to plugin process", "error", err)
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	var killed bool
+	select {
+	case <-s.Exited():
+	case <-timer.C:
+		killed = true
+	case <-ctx.Done():
+		killed = true
+	}
+
+	if killed {
+		logger.Warn("plugin process did not exit in time, killing it", "grace_period", grace)
+		if err := s.signal(syscall.SIGKILL); err != nil {
+			logger.Error("failed to kill plugin process", "error", err)
+			return errors.Wrapf(err, "failed to kill plugin process with PID %d", s.Process.Pid)
+		}
+		<-s.Exited()
+	}
+
+	logger.Debug("plugin process exited", "exit_code", s.ExitCode(), "killed", killed)
+	if killed {
+		return errors.Errorf("plugin process with PID %d did not exit in time and was killed", s.Process.Pid)
+	}
+	return nil
+}
+
+// Resources are the runner-wide facilities shared by every plugin.
+type Resources struct {
+	TLS                *config.TLSConfig
+	TransportGenerator *transport.TransportGenerator
+	BuildCache         *buildcache.BuildCache
+	// SocketDir is the private directory holding the sockets of plugins
+	// using the unix transport.
+	SocketDir string
+	// ShutdownGracePeriod is how long Close waits for a plugin to exit after
+	// SIGTERM before killing it.
+	ShutdownGracePeriod time.Duration
+	// Host serves the host services, nil if the runner offers none.
+	Host *HostServer
+
+	// instances numbers the started plugin instances.
+	instances atomic.Uint64
+	// brokerIDs numbers the sub-connections the runner picks for all
+	// plugins.
+	brokerIDs atomic.Uint32
+}
+
+// resolvePluginPath returns the absolute location of a plugin, resolving
+// relative manifest paths against the current working directory.
+func resolvePluginPath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get working directory")
+	}
+	return filepath.Join(wd, path), nil
 }
 
 func buildAndRunPlugin[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], options *PluginServerOptions) (*PluginServerConf, error) {
 	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
 	logger.Debug("starting plugin build and run")
 
-	wd, err := os.Getwd()
+	pluginPath, err := resolvePluginPath(pluginConfig.Path)
 	if err != nil {
-		logger.Error("failed to get working directory", "error", err)
-		return nil, errors.Wrap(err, "failed to get working directory")
+		logger.Error("failed to resolve plugin path", "error", err)
+		return nil, errors.Wrap(err, "failed to resolve plugin path")
 	}
 
 	cliOptions, err := options.ToCliOptions()
@@ -50,76 +183,233 @@ func buildAndRunPlugin[T any](ctx context.Context, pluginConfig config.ManifestP
 		return nil, errors.Wrap(err, "failed to generate CLI options")
 	}
 
-	pluginPath := filepath.Join(wd, pluginConfig.Path)
 	logger.Debug("building and running plugin", "path", pluginPath, "cli_options", cliOptions)
 
 	cmd := exec.CommandContext(ctx, "/usr/bin/env", append([]string{"go", "run", "./..."}, cliOptions...)...)
 	cmd.Dir = pluginPath
-	cmd.Env = os.Environ()
+	return runPluginProcess(ctx, logger, pluginConfig, cmd, options)
+}
+
+func execPlugin[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], options *PluginServerOptions) (*PluginServerConf, error) {
+	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
+	logger.Debug("starting prebuilt plugin")
+
+	binaryPath, err := resolvePluginPath(pluginConfig.Path)
+	if err != nil {
+		logger.Error("failed to resolve plugin path", "error", err)
+		return nil, errors.Wrap(err, "failed to resolve plugin path")
+	}
+
+	cliOptions, err := options.ToCliOptions()
+	if err != nil {
+		logger.Error("failed to generate CLI options", "error", err)
+		return nil, errors.Wrap(err, "failed to generate CLI options")
+	}
+
+	logger.Debug("running plugin binary", "path", binaryPath, "cli_options", cliOptions)
+
+	cmd := exec.CommandContext(ctx, binaryPath, cliOptions...)
+	cmd.Dir = filepath.Dir(binaryPath)
+	return runPluginProcess(ctx, logger, pluginConfig, cmd, options)
+}
+
+func buildPlugin[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], options *PluginServerOptions, buildCache *buildcache.BuildCache) (*PluginServerConf, error) {
+	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
+	logger.Debug("starting cached plugin build")
+
+	pluginPath, err := resolvePluginPath(pluginConfig.Path)
+	if err != nil {
+		logger.Error("failed to resolve plugin path", "error", err)
+		return nil, errors.Wrap(err, "failed to resolve plugin path")
+	}
+
+	binaryPath, err := buildCache.Build(ctx, pluginPath)
+	if err != nil {
+		logger.Error("failed to build plugin", "error", err)
+		return nil, errors.Wrapf(err, "failed to build plugin %s", pluginConfig.GetName())
+	}
+
+	cliOptions, err := options.ToCliOptions()
+	if err != nil {
+		logger.Error("failed to generate CLI options", "error", err)
+		return nil, errors.Wrap(err, "failed to generate CLI options")
+	}
+
+	logger.Debug("running built plugin", "path", pluginPath, "binary", binaryPath, "cli_options", cliOptions)
+
+	cmd := exec.CommandContext(ctx, binaryPath, cliOptions...)
+	cmd.Dir = pluginPath
+	return runPluginProcess(ctx, logger, pluginConfig, cmd, options)
+}
+
+// runPluginProcess starts cmd in its own process group and hands it its
+// secrets and config. Readiness is checked separately, see waitForServing.
+func runPluginProcess(ctx context.Context, logger *slog.Logger, pluginConfig config.ManifestPlugin, cmd *exec.Cmd, options *PluginServerOptions) (*PluginServerConf, error) {
+	if pluginConfig.Workdir != "" {
+		workdir, err := resolvePluginPath(pluginConfig.Workdir)
+		if err != nil {
+			logger.Error("failed to resolve plugin workdir", "error", err)
+			return nil, errors.Wrap(err, "failed to resolve plugin workdir")
+		}
+		cmd.Dir = workdir
+	}
+	cmd.Env = pluginConfig.Env.Environ(os.Environ())
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
+
+	pipes, err := options.pipes()
+	if err != nil {
+		logger.Error("failed to prepare plugin secrets", "error", err)
+		return nil, errors.Wrap(err, "failed to prepare plugin secrets")
+	}
+
+	readers := make([]*os.File, 0, len(pipes))
+	writers := make([]*os.File, 0, len(pipes))
+	for range pipes {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			logger.Error("failed to create secrets pipe", "error", err)
+			return nil, errors.Wrap(err, "failed to create secrets pipe")
+		}
+		defer reader.Close()
+		defer writer.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, reader)
+		readers = append(readers, reader)
+		writers = append(writers, writer)
+	}
+
+	// The handshake pipe goes the other way and follows the payload pipes,
+	// see PluginServerOptions.ToCliOptions.
+	handshakeReader, handshakeWriter, err := os.Pipe()
+	if err != nil {
+		logger.Error("failed to create handshake pipe", "error", err)
+		return nil, errors.Wrap(err, "failed to create handshake pipe")
+	}
+	defer handshakeWriter.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, handshakeWriter)
+
 	err = cmd.Start()
 	if err != nil {
+		handshakeReader.Close()
 		logger.Error("failed to start plugin process", "error", err)
-		return nil, errors.Wrapf(err, "failed to start plugin process at %s", pluginPath)
+		return nil, errors.Wrapf(err, "failed to start plugin process %s", cmd.Path)
+	}
+	// Only the plugin may hold the write end, so that reading the handshake
+	// ends when the plugin exits. Likewise for the read ends of the payload
+	// pipes, so that writing them fails instead of blocking once the pipe is
+	// full and the plugin exited.
+	handshakeWriter.Close()
+	for _, reader := range readers {
+		reader.Close()
 	}
 
-	logger.Info("plugin process started", "pid", cmd.Process.Pid, "port", options.Port)
-
-	// Wait for the plugin to start
-	startCtx, cancel := context.WithTimeout(ctx, startupTimeout)
-	defer cancel()
+	server := &PluginServerConf{
+		Process:         cmd.Process,
+		StartedAt:       time.Now(),
+		cmd:             cmd,
+		exited:          make(chan struct{}),
+		handshake:       handshakeReader,
+		network:         options.network(),
+		certFingerprint: options.KeyAndCert.Fingerprint(),
+		revokeHost:      options.revokeHost,
+	}
+	go server.wait()
 
-	// Try to connect to the plugin
-	for {
-		select {
-		case <-startCtx.Done():
-			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM); err != nil {
-				logger.Error("failed to kill plugin process after timeout", "error", err)
-			}
-			return nil, errors.Wrapf(startCtx.Err(), "plugin %s failed to start within %v", pluginConfig.GetName(), startupTimeout)
-		case <-ctx.Done():
-			if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM); err != nil {
-				logger.Error("failed to kill plugin process after context cancellation", "error", err)
-			}
-			return nil, errors.Wrap(ctx.Err(), "context cancelled while waiting for plugin to start")
-		default:
-			conn, err := net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(options.Port)), time.Second)
-			if err == nil {
-				conn.Close()
-				return &PluginServerConf{
-					Port:    options.Port,
-					Process: cmd.Process,
-				}, nil
+	for i, writer := range writers {
+		// The plugin reads every pipe until EOF, in order, so the write end
+		// must be closed once the payload is written.
+		_, err := writer.Write(pipes[i].payload)
+		writer.Close()
+		if err != nil {
+			logger.Error("failed to send secrets to plugin", "error", err)
+			if killErr := server.signal(syscall.SIGTERM); killErr != nil {
+				logger.Error("failed to kill plugin process after secrets error", "error", killErr)
 			}
-			time.Sleep(100 * time.Millisecond)
+			handshakeReader.Close()
+			return nil, errors.Wrap(err, "failed to send secrets to plugin")
 		}
 	}
+
+	logger.Info("plugin process started", "pid", cmd.Process.Pid)
+	return server, nil
 }
 
 type PluginServerOptions struct {
-	Port          int
+	// SocketPath makes the plugin listen on a unix socket instead of a TCP
+	// port picked by the OS.
+	SocketPath    string
 	KeyAndCert    *transport.KeyAndCert
 	LoggerOptions *config.LoggerOptions
 	PluginName    string
+	// Config is the JSON encoded plugin config, sent over its own pipe.
+	Config []byte
+	// Host is the JSON encoded transport.HostInfo, sent over its own pipe.
+	// Left empty when the host offers no services.
+	Host []byte
+	// revokeHost revokes the client certificate in Host once the plugin
+	// exits.
+	revokeHost func()
+	// Args are appended after the flags.
+	Args []string
 }
 
-func (options *PluginServerOptions) ToCliOptions() ([]string, error) {
-	opts := []string{}
-	if options.Port != 0 {
-		opts = append(opts, "-port", fmt.Sprintf("%d", options.Port))
+// network returns the network the plugin started with these options serves
+// on.
+func (options *PluginServerOptions) network() string {
+	if options.SocketPath != "" {
+		return "unix"
 	}
+	return "tcp"
+}
+
+// pipe is a payload sent to the plugin over an inherited pipe, announced by
+// flag.
+type pipe struct {
+	flag    string
+	payload []byte
+}
+
+// pipes returns the pipes passed to the plugin, in order, starting at
+// firstPipeFD.
+func (options *PluginServerOptions) pipes() ([]pipe, error) {
+	var pipes []pipe
 	if options.KeyAndCert != nil {
 		keyAndCertBytes, err := options.KeyAndCert.Serialize()
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to serialize key and cert")
 		}
-		opts = append(opts, "-tls_key_and_cert", string(keyAndCertBytes))
+		pipes = append(pipes, pipe{flag: "-tls_key_and_cert_fd", payload: keyAndCertBytes})
+	}
+	// The config and host pipes are only passed when there is something to
+	// send, plugins built against older versions of this library do not know
+	// their flags.
+	if len(options.Config) > 0 {
+		pipes = append(pipes, pipe{flag: "-config_fd", payload: options.Config})
 	}
+	if len(options.Host) > 0 {
+		pipes = append(pipes, pipe{flag: "-host_fd", payload: options.Host})
+	}
+	return pipes, nil
+}
+
+func (options *PluginServerOptions) ToCliOptions() ([]string, error) {
+	opts := []string{}
+	if options.SocketPath != "" {
+		opts = append(opts, "-socket", options.SocketPath)
+	}
+	pipes, err := options.pipes()
+	if err != nil {
+		return nil, err
+	}
+	for i, pipe := range pipes {
+		opts = append(opts, pipe.flag, strconv.Itoa(firstPipeFD+i))
+	}
+	// The handshake pipe follows the payload pipes.
+	opts = append(opts, "-handshake_fd", strconv.Itoa(firstPipeFD+len(pipes)))
 	if options.PluginName != "" {
 		opts = append(opts, "-plugin_name", options.PluginName)
 	}
@@ -130,44 +420,85 @@ func (options *PluginServerOptions) ToCliOptions() ([]string, error) {
 		}
 		opts = append(opts, "-logger_options", string(loggerOptsJSON))
 	}
+	opts = append(opts, options.Args...)
 	return opts, nil
 }
 
-func startPluginServer[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], transportGenerator *transport.TransportGenerator, portMgr *portmanager.PortManager) (*PluginServerConf, error) {
+// startPluginServer starts the plugin process. Its server only accepts the
+// client certificate with the fingerprint clientFingerprint.
+func startPluginServer[T any](ctx context.Context, pluginConfig config.ManifestPlugin, cfg *config.Config[T], resources *Resources, clientFingerprint string) (*PluginServerConf, error) {
 	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
 	logger.Debug("starting plugin server")
 
-	serverKeyAndCert, err := transportGenerator.GenerateKeyAndCert(pluginConfig.GetName(), "server")
+	serverKeyAndCert, err := resources.TransportGenerator.KeyAndCertForPlugin(pluginConfig, transport.RoleServer)
 	if err != nil {
 		logger.Error("failed to generate server key and cert", "error", err)
 		return nil, errors.Wrapf(err, "failed to generate server key and cert for plugin %s", pluginConfig.GetName())
 	}
-
-	port, err := portMgr.GetPort()
-	if err != nil {
-		logger.Error("failed to get port", "error", err)
-		return nil, errors.Wrap(err, "failed to get available port")
-	}
+	serverKeyAndCert.AllowedClients = []string{clientFingerprint}
 
 	options := &PluginServerOptions{
 		KeyAndCert:    serverKeyAndCert,
-		Port:          port,
 		LoggerOptions: cfg.LoggerOptions,
 		PluginName:    pluginConfig.GetName(),
+		Args:          pluginConfig.Args,
+	}
+
+	if len(pluginConfig.Config) > 0 {
+		options.Config, err = json.Marshal(pluginConfig.Config)
+		if err != nil {
+			logger.Error("failed to encode plugin config", "error", err)
+			return nil, errors.Wrapf(err, "failed to encode config of plugin %s", pluginConfig.GetName())
+		}
+	}
+
+	switch transportKind := pluginConfig.GetTransport(""); transportKind {
+	case "unix":
+		if resources.SocketDir == "" {
+			return nil, errors.New("no socket directory available for unix transport")
+		}
+		// Every instance gets its own socket, during an upgrade the old and
+		// the new instance of a plugin run side by side.
+		socketName := fmt.Sprintf("%s-%d.sock", pluginConfig.GetName(), resources.instances.Add(1))
+		socketPath := filepath.Join(resources.SocketDir, socketName)
+		// A socket left behind by a previous runner would make listen fail.
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			logger.Error("failed to remove stale socket", "error", err, "socket", socketPath)
+			return nil, errors.Wrapf(err, "failed to remove stale socket %s", socketPath)
+		}
+		options.SocketPath = socketPath
+	case "tcp":
+		// The plugin listens on a port picked by the OS and reports it in its
+		// handshake.
+	default:
+		return nil, errors.Errorf("transport %q is not supported", transportKind)
+	}
+
+	if resources.Host != nil {
+		options.Host, options.revokeHost, err = resources.Host.issue(pluginConfig.GetName())
+		if err != nil {
+			logger.Error("failed to issue host credentials", "error", err)
+			return nil, errors.Wrapf(err, "failed to issue host credentials for plugin %s", pluginConfig.GetName())
+		}
 	}
 
 	var pluginServer *PluginServerConf
 	var startErr error
 
-	if pluginConfig.Kind == "build_and_run" {
+	switch pluginConfig.Kind {
+	case "build_and_run":
 		pluginServer, startErr = buildAndRunPlugin(ctx, pluginConfig, cfg, options)
-	} else {
+	case "build":
+		pluginServer, startErr = buildPlugin(ctx, pluginConfig, cfg, options, resources.BuildCache)
+	case "exec":
+		pluginServer, startErr = execPlugin(ctx, pluginConfig, cfg, options)
+	default:
 		startErr = errors.Errorf("plugin kind %q is not supported", pluginConfig.Kind)
 	}
 
 	if startErr != nil {
-		if err := portMgr.ReleasePort(port); err != nil {
-			logger.Error("failed to release port after error", "error", err)
+		if options.revokeHost != nil {
+			options.revokeHost()
 		}
 		return nil, startErr
 	}
@@ -175,70 +506,32 @@ func startPluginServer[T any](ctx context.Context, pluginConfig config.ManifestP
 	return pluginServer, nil
 }
 
-func createPluginClient[T any](pluginServer *PluginServerConf, pluginConfig config.ManifestPlugin, cfg *config.Config[T], transportGenerator *transport.TransportGenerator) (T, error) {
+// dialPlugin creates a client for pluginServer presenting keyAndCert.
+func dialPlugin(pluginServer *PluginServerConf, pluginConfig config.ManifestPlugin, keyAndCert *transport.KeyAndCert) (*grpc.ClientConn, error) {
 	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
 	logger.Debug("creating plugin client")
 
-	var nilt T
-	keyAndCert, err := transportGenerator.GenerateKeyAndCert(pluginConfig.GetName()+"_client", "client")
-	if err != nil {
-		logger.Error("failed to generate client key and cert", "error", err)
-		return nilt, errors.Wrapf(err, "failed to generate client key and cert for plugin %s", pluginConfig.GetName())
-	}
-
 	clientTLSConfig, err := keyAndCert.GetTLSConfig()
 	if err != nil {
 		logger.Error("failed to get client TLS config", "error", err)
-		return nilt, errors.Wrapf(err, "failed to get client TLS config for plugin %s", pluginConfig.GetName())
+		return nil, errors.Wrapf(err, "failed to get client TLS config for plugin %s", pluginConfig.GetName())
 	}
+	// Plugins report a loopback IP, which pre-issued certificates need not
+	// cover.
+	clientTLSConfig.ServerName = transport.ServerName
 
-	addr := net.JoinHostPort("localhost", strconv.Itoa(pluginServer.Port))
+	addr := pluginServer.Address
+	if pluginServer.Network == "unix" {
+		addr = "unix://" + pluginServer.Address
+	}
 	logger.Debug("connecting to plugin server", "address", addr)
 
 	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)))
 	if err != nil {
 		logger.Error("failed to create gRPC client", "error", err)
-		return nilt, errors.Wrapf(err, "failed to create gRPC client for plugin %s at %s", pluginConfig.GetName(), addr)
+		return nil, errors.Wrapf(err, "failed to create gRPC client for plugin %s at %s", pluginConfig.GetName(), addr)
 	}
 
 	logger.Info("plugin client created successfully")
-	return cfg.PluginGenerator(conn), nil
-}
-
-func (l *LoadedPlugin[T]) Close() error {
-	if l.Server.Process != nil {
-		err := syscall.Kill(-l.Server.Process.Pid, syscall.SIGTERM)
-		if err != nil {
-			slog.Error("failed to terminate plugin process", "error", err, "pid", l.Server.Process.Pid)
-			return errors.Wrapf(err, "failed to terminate plugin process with PID %d", l.Server.Process.Pid)
-		}
-		slog.Debug("plugin process terminated", "pid", l.Server.Process.Pid)
-	}
-	return nil
-}
-
-func LoadPlugin[T any](ctx context.Context, pluginConfig config.ManifestPlugin, transportGenerator *transport.TransportGenerator, cfg *config.Config[T], portMgr *portmanager.PortManager) (*LoadedPlugin[T], error) {
-	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
-	logger.Info("loading plugin")
-
-	pluginServer, err := startPluginServer(ctx, pluginConfig, cfg, transportGenerator, portMgr)
-	if err != nil {
-		logger.Error("failed to start plugin server", "error", err)
-		return nil, errors.Wrapf(err, "failed to start server for plugin %s", pluginConfig.GetName())
-	}
-
-	pluginClient, err := createPluginClient(pluginServer, pluginConfig, cfg, transportGenerator)
-	if err != nil {
-		logger.Error("failed to create plugin client", "error", err)
-		if closeErr := syscall.Kill(-pluginServer.Process.Pid, syscall.SIGTERM); closeErr != nil {
-			logger.Error("failed to kill plugin process after client creation error", "error", closeErr)
-		}
-		return nil, errors.Wrapf(err, "failed to create client for plugin %s", pluginConfig.GetName())
-	}
-
-	logger.Info("plugin loaded successfully")
-	return &LoadedPlugin[T]{
-		Plugin: pluginClient,
-		Server: pluginServer,
-	}, nil
+	return conn, nil
 }
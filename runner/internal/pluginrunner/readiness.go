@@ -0,0 +1,54 @@
+package pluginrunner
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	readinessPollInterval = 100 * time.Millisecond
+	readinessCheckTimeout = time.Second
+)
+
+// waitForServing polls the plugin's grpc.health.v1 service over conn until it
+// reports SERVING, the plugin exits or ctx is done.
+func waitForServing(ctx context.Context, pluginConfig config.ManifestPlugin, pluginServer *PluginServerConf, conn *grpc.ClientConn) error {
+	logger := slog.With("component", "plugin_runner", "plugin", pluginConfig.GetName())
+	logger.Debug("waiting for plugin to report serving")
+
+	client := healthpb.NewHealthClient(conn)
+	lastStatus := "no response"
+	for {
+		checkCtx, checkCancel := context.WithTimeout(ctx, readinessCheckTimeout)
+		resp, err := client.Check(checkCtx, &healthpb.HealthCheckRequest{})
+		checkCancel()
+
+		switch {
+		case err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING:
+			logger.Debug("plugin is serving")
+			return nil
+		case err == nil:
+			lastStatus = resp.GetStatus().String()
+		case status.Code(err) == codes.Unimplemented:
+			return errors.Errorf("plugin %s does not implement grpc.health.v1, rebuild it against a newer version of this library", pluginConfig.GetName())
+		default:
+			lastStatus = err.Error()
+		}
+
+		select {
+		case <-pluginServer.Exited():
+			return errors.Errorf("plugin %s exited during startup: %v", pluginConfig.GetName(), pluginServer.ExitErr())
+		case <-ctx.Done():
+			return errors.Wrapf(context.Cause(ctx), "plugin %s did not report serving, last status: %s", pluginConfig.GetName(), lastStatus)
+		case <-time.After(readinessPollInterval):
+		}
+	}
+}
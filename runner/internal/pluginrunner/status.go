@@ -0,0 +1,150 @@
+package pluginrunner
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// healthRetryInterval is how long the health watcher waits before
+	// re-opening a failed Watch stream.
+	healthRetryInterval = time.Second
+)
+
+// State is the lifecycle state of a loaded plugin.
+type State string
+
+const (
+	StateStarting   State = "starting"
+	StateReady      State = "ready"
+	StateUnhealthy  State = "unhealthy"
+	StateRestarting State = "restarting"
+	StateStopped    State = "stopped"
+)
+
+// Status is a snapshot of a plugin's state.
+type Status struct {
+	Name  string
+	State State
+	// LastError is the most recent health, exit or restart error, if any.
+	LastError error
+	Restarts  int
+	// ProtocolVersion is the protocol version the plugin declared, 0 for
+	// none.
+	ProtocolVersion int
+	// PID and StartedAt describe the current process. PID is 0 when no
+	// process is running.
+	PID       int
+	StartedAt time.Time
+	Uptime    time.Duration
+}
+
+// Status returns a snapshot of the plugin's state.
+func (l *LoadedPlugin[T]) Status() Status {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := Status{
+		Name:      l.pluginConfig.GetName(),
+		State:     l.state,
+		LastError: l.lastErr,
+		Restarts:  l.restarts,
+
+		ProtocolVersion: l.protocolVersion,
+	}
+	if l.server != nil && l.state != StateStopped && l.state != StateRestarting {
+		status.PID = l.server.Process.Pid
+		status.StartedAt = l.server.StartedAt
+		status.Uptime = time.Since(l.server.StartedAt)
+	}
+	return status
+}
+
+// State returns the plugin's lifecycle state.
+func (l *LoadedPlugin[T]) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// setState records state and, if not nil, err as the last error.
+func (l *LoadedPlugin[T]) setState(state State, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.setStateLocked(state, err)
+}
+
+func (l *LoadedPlugin[T]) setStateLocked(state State, err error) {
+	if l.state != state {
+		l.logger.Debug("plugin state changed", "from", l.state, "to", state)
+	}
+	l.state = state
+	if err != nil {
+		l.lastErr = err
+	}
+}
+
+// setHealth applies a health report of server, ignoring reports from
+// instances that were replaced and reports arriving while the plugin is
+// restarting or stopped.
+func (l *LoadedPlugin[T]) setHealth(server *PluginServerConf, healthy bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.server != server || (l.state != StateReady && l.state != StateUnhealthy) {
+		return
+	}
+	if healthy {
+		l.setStateLocked(StateReady, nil)
+	} else {
+		l.setStateLocked(StateUnhealthy, err)
+	}
+}
+
+// watchHealth follows the grpc.health.v1 Watch stream of one plugin instance
+// until the instance exits or the plugin is closed.
+func (l *LoadedPlugin[T]) watchHealth(server *PluginServerConf, conn *grpc.ClientConn) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-server.Exited():
+		case <-l.closing:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	client := healthpb.NewHealthClient(conn)
+	for {
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		for err == nil {
+			var resp *healthpb.HealthCheckResponse
+			resp, err = stream.Recv()
+			if err == nil {
+				serving := resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+				l.setHealth(server, serving, errors.Errorf("plugin reported %s", resp.GetStatus()))
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if l.Server() != server {
+			// The instance was replaced by Upgrade and its connection closed.
+			return
+		}
+		l.logger.Warn("plugin health watch failed", "error", err)
+		l.setHealth(server, false, errors.Wrap(err, "health watch failed"))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthRetryInterval):
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package pluginsloader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner"
+)
+
+const (
+	// handleRetryInterval is how often a waiting Handle checks whether its
+	// plugin became ready.
+	handleRetryInterval = 100 * time.Millisecond
+)
+
+// HandleOptions control how a Handle behaves while its plugin is not ready.
+type HandleOptions struct {
+	// WaitForReady makes calls block until the plugin is ready or the call's
+	// context is done. By default calls fail with codes.Unavailable right
+	// away.
+	WaitForReady bool
+}
+
+// Handle is a client for a plugin that stays valid for the lifetime of the
+// loaded plugins. Every call is routed to the instance of the named plugin
+// that is running at that moment, so it survives restarts, upgrades and
+// reloads and may be cached by the application.
+type Handle[T any] struct {
+	name    string
+	plugins *LoadedPlugins[T]
+	conn    *handleConn[T]
+
+	mu      sync.Mutex
+	clients map[int]T
+}
+
+// Name returns the name of the plugin the handle routes to.
+func (h *Handle[T]) Name() string {
+	return h.name
+}
+
+// Client returns the plugin client for the protocol version the plugin
+// speaks, or for the host's ProtocolVersion while it is not loaded. Clients
+// are generated once per version, so the result may be kept as long as the
+// plugin is not reloaded with another protocol version.
+func (h *Handle[T]) Client() T {
+	version := h.plugins.cfg.ProtocolVersion
+	h.plugins.mu.RLock()
+	if plugin, ok := h.plugins.pluginsMap[h.name]; ok {
+		version = plugin.ProtocolVersion()
+	}
+	h.plugins.mu.RUnlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if client, ok := h.clients[version]; ok {
+		return client
+	}
+	generator, err := h.plugins.cfg.NegotiateProtocol(version)
+	if err != nil {
+		generator = h.plugins.cfg.PluginGenerator
+	}
+	client := generator(h.conn)
+	h.clients[version] = client
+	return client
+}
+
+// Handle returns a stable handle for the named plugin. The plugin does not
+// have to be loaded yet, calls are routed to it once it is.
+func (l *LoadedPlugins[T]) Handle(name string, options HandleOptions) *Handle[T] {
+	return &Handle[T]{
+		name:    name,
+		plugins: l,
+		conn: &handleConn[T]{
+			plugins: l,
+			name:    name,
+			options: options,
+		},
+		clients: make(map[int]T),
+	}
+}
+
+// handleConn is the grpc.ClientConnInterface behind a Handle. It looks the
+// plugin up on every call.
+type handleConn[T any] struct {
+	plugins *LoadedPlugins[T]
+	name    string
+	options HandleOptions
+}
+
+// current returns the connection of the plugin once it is ready, waiting for
+// it if the handle was created with WaitForReady.
+func (c *handleConn[T]) current(ctx context.Context) (grpc.ClientConnInterface, error) {
+	for {
+		conn, final, err := c.ready()
+		if err == nil || final || !c.options.WaitForReady {
+			return conn, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(handleRetryInterval):
+		}
+	}
+}
+
+// ready returns the connection of the plugin or a codes.Unavailable error if
+// it is not loaded or not ready. final is set if waiting will not help.
+func (c *handleConn[T]) ready() (conn grpc.ClientConnInterface, final bool, err error) {
+	c.plugins.mu.RLock()
+	defer c.plugins.mu.RUnlock()
+
+	if c.plugins.closed {
+		return nil, true, status.Error(codes.Unavailable, "plugins are closed")
+	}
+	plugin, ok := c.plugins.pluginsMap[c.name]
+	if !ok {
+		return nil, false, status.Errorf(codes.Unavailable, "plugin %s is not loaded", c.name)
+	}
+	if state := plugin.State(); state != pluginrunner.StateReady {
+		return nil, false, status.Errorf(codes.Unavailable, "plugin %s is %s", c.name, state)
+	}
+	return plugin.Conn(), false, nil
+}
+
+func (c *handleConn[T]) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	conn, err := c.current(ctx)
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+func (c *handleConn[T]) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	conn, err := c.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.NewStream(ctx, desc, method, opts...)
+}
@@ -0,0 +1,286 @@
+package pluginsloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner"
+)
+
+// prepare resolves the transport of plugin and creates the socket directory
+// the first time a plugin uses the unix transport.
+func (l *LoadedPlugins[T]) prepare(plugin *config.ManifestPlugin) error {
+	plugin.Transport = plugin.GetTransport(l.transport)
+	if plugin.Transport != "unix" {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.resources.SocketDir != "" {
+		return nil
+	}
+	// MkdirTemp creates the directory with 0700 so only the current user can
+	// reach the plugin sockets.
+	socketDir, err := os.MkdirTemp("", "grpc-plugin-")
+	if err != nil {
+		l.logger.Error("failed to create socket directory", "error", err)
+		return errors.Wrap(err, "failed to create socket directory")
+	}
+	l.resources.SocketDir = socketDir
+	l.logger.Debug("socket directory created", "dir", socketDir)
+	return nil
+}
+
+// validate checks that plugin can be loaded next to the running plugins. The
+// plugin named replacing, if any, is about to be replaced by plugin and does
+// not conflict with it.
+func (l *LoadedPlugins[T]) validate(plugin *config.ManifestPlugin, replacing string) error {
+	if err := plugin.Validate(); err != nil {
+		return errors.Wrap(err, "invalid plugin")
+	}
+	if err := l.resources.TLS.ValidatePlugin(plugin); err != nil {
+		return errors.Wrap(err, "invalid plugin TLS configuration")
+	}
+
+	absPath, err := filepath.Abs(plugin.Path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get absolute path for plugin %q", plugin.GetName())
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return errors.New("plugins are closed")
+	}
+	if _, exists := l.pluginsMap[plugin.GetName()]; exists && plugin.GetName() != replacing {
+		return errors.Errorf("plugin %q is already loaded", plugin.GetName())
+	}
+	for name, loaded := range l.pluginsMap {
+		if name == replacing {
+			continue
+		}
+		loadedConfig := loaded.Config()
+		loadedPath, err := filepath.Abs(loadedConfig.Path)
+		if err == nil && loadedPath == absPath {
+			return errors.Errorf("plugin path %q is already used by plugin %q", absPath, name)
+		}
+	}
+	return nil
+}
+
+// Load starts the plugin described by pluginConfig and adds it to the loaded
+// plugins. ctx bounds the startup only, the plugin keeps running until it is
+// unloaded or the context passed to LoadAll is done.
+func (l *LoadedPlugins[T]) Load(ctx context.Context, pluginConfig config.ManifestPlugin) error {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	return l.load(ctx, pluginConfig)
+}
+
+func (l *LoadedPlugins[T]) load(ctx context.Context, pluginConfig config.ManifestPlugin) error {
+	name := pluginConfig.GetName()
+	logger := l.logger.With("plugin", name)
+	logger.Debug("loading plugin at runtime", "path", pluginConfig.Path, "kind", pluginConfig.Kind)
+
+	if err := l.validate(&pluginConfig, ""); err != nil {
+		logger.Error("cannot load plugin", "error", err)
+		return errors.Wrapf(err, "cannot load plugin %s", name)
+	}
+	if err := l.prepare(&pluginConfig); err != nil {
+		return err
+	}
+
+	// The plugin must outlive ctx, so it runs on a context derived from the
+	// one of LoadAll which is only cancelled if ctx ends during startup.
+	pluginCtx, cancel := context.WithCancel(l.ctx)
+	stop := context.AfterFunc(ctx, cancel)
+
+	plugin := pluginrunner.NewPlugin(pluginCtx, pluginConfig, l.cfg, l.resources)
+	err := l.start(name, plugin)
+	if !stop() {
+		l.stopStarting(name)
+		if err == nil {
+			plugin.Close()
+		}
+		return errors.Wrapf(ctx.Err(), "loading plugin %s cancelled", name)
+	}
+	if err != nil {
+		l.stopStarting(name)
+		cancel()
+		logger.Error("failed to load plugin", "error", err)
+		return errors.Wrapf(err, "failed to load plugin %s", name)
+	}
+
+	l.mu.Lock()
+	delete(l.starting, name)
+	if l.closed {
+		l.mu.Unlock()
+		plugin.Close()
+		cancel()
+		return errors.Errorf("plugins were closed while loading plugin %s", name)
+	}
+	l.pluginsMap[name] = plugin
+	if l.cancels == nil {
+		l.cancels = make(map[string]context.CancelFunc)
+	}
+	l.cancels[name] = cancel
+	l.mu.Unlock()
+
+	logger.Info("plugin loaded successfully")
+	return nil
+}
+
+// start starts plugin and reports it as starting until the caller moves it
+// to pluginsMap or calls stopStarting.
+func (l *LoadedPlugins[T]) start(name string, plugin *pluginrunner.LoadedPlugin[T]) error {
+	l.mu.Lock()
+	l.starting[name] = plugin
+	l.mu.Unlock()
+
+	return plugin.Start()
+}
+
+// stopStarting stops reporting the named plugin as starting.
+func (l *LoadedPlugins[T]) stopStarting(name string) {
+	l.mu.Lock()
+	delete(l.starting, name)
+	l.mu.Unlock()
+}
+
+// Unload removes the named plugin and shuts it down like CloseContext does.
+func (l *LoadedPlugins[T]) Unload(ctx context.Context, name string) error {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	_, err := l.unload(ctx, name)
+	return err
+}
+
+// unload removes the named plugin, closes it and returns its configuration.
+func (l *LoadedPlugins[T]) unload(ctx context.Context, name string) (config.ManifestPlugin, error) {
+	l.mu.Lock()
+	plugin, ok := l.pluginsMap[name]
+	if !ok {
+		l.mu.Unlock()
+		l.logger.Error("plugin not found", "plugin", name)
+		return config.ManifestPlugin{}, errors.Errorf("plugin %q not found", name)
+	}
+	delete(l.pluginsMap, name)
+	cancel := l.cancels[name]
+	delete(l.cancels, name)
+	l.mu.Unlock()
+
+	logger := l.logger.With("plugin", name)
+	logger.Debug("unloading plugin")
+
+	err := plugin.CloseContext(ctx)
+	if cancel != nil {
+		cancel()
+	}
+	if err != nil {
+		logger.Error("failed to close plugin", "error", err)
+		return plugin.Config(), errors.Wrapf(err, "failed to close plugin %s", name)
+	}
+
+	logger.Info("plugin unloaded successfully")
+	return plugin.Config(), nil
+}
+
+// Reload starts the named plugin again from the same manifest entry, picking
+// up a rebuilt binary, changed sources or a new protocol version. The new
+// instance is started next to the running one, which is only shut down once
+// the new one is ready and keeps running if it fails to start. Clients
+// returned by GetPlugin before the reload no longer reach the plugin.
+func (l *LoadedPlugins[T]) Reload(ctx context.Context, name string) error {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	plugin, err := l.GetRawPlugin(name)
+	if err != nil {
+		return err
+	}
+	return l.reload(ctx, name, plugin.Config())
+}
+
+// reload replaces the named plugin with one started from pluginConfig, see
+// Reload.
+func (l *LoadedPlugins[T]) reload(ctx context.Context, name string, pluginConfig config.ManifestPlugin) error {
+	logger := l.logger.With("plugin", name)
+	logger.Debug("reloading plugin", "path", pluginConfig.Path, "kind", pluginConfig.Kind)
+
+	if err := l.validate(&pluginConfig, name); err != nil {
+		logger.Error("cannot reload plugin", "error", err)
+		return errors.Wrapf(err, "cannot reload plugin %s", name)
+	}
+	if err := l.prepare(&pluginConfig); err != nil {
+		return err
+	}
+
+	// Like in load, the new instance runs on a context derived from the one
+	// of LoadAll.
+	pluginCtx, cancel := context.WithCancel(l.ctx)
+	stop := context.AfterFunc(ctx, cancel)
+
+	plugin := pluginrunner.NewPlugin(pluginCtx, pluginConfig, l.cfg, l.resources)
+	err := plugin.Start()
+	if !stop() {
+		if err == nil {
+			plugin.Close()
+		}
+		return errors.Wrapf(ctx.Err(), "reloading plugin %s cancelled", name)
+	}
+	if err != nil {
+		cancel()
+		logger.Error("failed to start new plugin instance, keeping the old one", "error", err)
+		return errors.Wrapf(err, "failed to reload plugin %s", name)
+	}
+
+	l.mu.Lock()
+	old, ok := l.pluginsMap[name]
+	if l.closed || !ok {
+		l.mu.Unlock()
+		plugin.Close()
+		cancel()
+		return errors.Errorf("plugin %s was closed while reloading", name)
+	}
+	l.pluginsMap[name] = plugin
+	oldCancel := l.cancels[name]
+	if l.cancels == nil {
+		l.cancels = make(map[string]context.CancelFunc)
+	}
+	l.cancels[name] = cancel
+	l.mu.Unlock()
+
+	err = old.CloseContext(ctx)
+	if oldCancel != nil {
+		oldCancel()
+	}
+	if err != nil {
+		logger.Warn("plugin reloaded but old instance did not stop cleanly", "error", err)
+		return errors.Wrapf(err, "plugin %s reloaded but old instance did not stop cleanly", name)
+	}
+
+	logger.Info("plugin reloaded successfully")
+	return nil
+}
+
+// Upgrade replaces the running instance of the named plugin with a new one
+// without downtime, see LoadedPlugin.Upgrade. Unlike Reload, clients returned
+// by GetPlugin keep working and are moved over to the new instance.
+func (l *LoadedPlugins[T]) Upgrade(ctx context.Context, name string) error {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	plugin, err := l.GetRawPlugin(name)
+	if err != nil {
+		return err
+	}
+	return plugin.Upgrade(ctx)
+}
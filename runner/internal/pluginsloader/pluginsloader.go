@@ -3,19 +3,59 @@ package pluginsloader
 import (
 	"context"
 	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/trustdsh/grpc-plugin/internal/transport"
 	"github.com/trustdsh/grpc-plugin/pkgs/config"
 	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner"
-	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner/portmanager"
+	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner/buildcache"
 )
 
-// LoadAll loads all plugins defined in the configuration
+// LoadErrors holds the error of every plugin that failed to load, keyed by
+// plugin name
+type LoadErrors map[string]error
+
+func (e LoadErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, "plugin "+name+": "+e[name].Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// LoadAll loads all plugins defined in the configuration, starting up to
+// max_parallel_loads of them at once. It fails if a required plugin cannot be
+// loaded; failures of optional plugins are only recorded in the load report.
 func LoadAll[T any](ctx context.Context, cfg config.Config[T]) (*LoadedPlugins[T], error) {
 	logger := slog.Default().With("component", "plugins_loader")
 	logger.Debug("starting plugins loading")
 
+	// The watcher compares against the manifest as it was before loading, so
+	// changes made while the plugins start up are not missed.
+	var fingerprint []byte
+	var err error
+	if cfg.Manifest != nil && cfg.Manifest.Watch {
+		fingerprint, err = cfg.Manifest.Fingerprint()
+		if err != nil {
+			logger.Warn("failed to read manifest", "error", err)
+		}
+	}
+
+	if err := cfg.ValidateProtocol(); err != nil {
+		logger.Error("invalid protocol configuration", "error", err)
+		return nil, errors.Wrap(err, "invalid protocol configuration")
+	}
+
 	pluginConfig, err := config.LoadManifest(&cfg)
 	if err != nil {
 		logger.Error("failed to load manifest", "error", err)
@@ -30,16 +70,33 @@ func LoadAll[T any](ctx context.Context, cfg config.Config[T]) (*LoadedPlugins[T
 	}
 	logger.Debug("transport generator created successfully")
 
-	portMgr := portmanager.New()
+	buildCache, err := buildcache.New(pluginConfig.BuildCacheDir)
+	if err != nil {
+		logger.Error("failed to create build cache", "error", err)
+		return nil, errors.Wrap(err, "failed to create build cache")
+	}
+
+	resources := &pluginrunner.Resources{
+		TLS:                 &pluginConfig.TLS,
+		TransportGenerator:  transportGenerator,
+		BuildCache:          buildCache,
+		ShutdownGracePeriod: pluginConfig.GetShutdownGracePeriod(),
+	}
 
 	plugins := &LoadedPlugins[T]{
 		pluginsMap:         make(map[string]*pluginrunner.LoadedPlugin[T]),
+		starting:           make(map[string]*pluginrunner.LoadedPlugin[T]),
 		TransportGenerator: transportGenerator,
 		logger:             logger,
-		portManager:        portMgr,
+		resources:          resources,
+		ctx:                ctx,
+		cfg:                &cfg,
+		transport:          pluginConfig.Transport,
+		buildCacheDir:      pluginConfig.BuildCacheDir,
 	}
 
-	// If loading fails, ensure we clean up any loaded plugins
+	// If loading fails, ensure we clean up any loaded plugins, the host
+	// server and the socket directory
 	var loadErr error
 	defer func() {
 		if loadErr != nil {
@@ -49,25 +106,114 @@ func LoadAll[T any](ctx context.Context, cfg config.Config[T]) (*LoadedPlugins[T
 		}
 	}()
 
-	for _, pluginConfig := range pluginConfig.Plugins {
-		select {
-		case <-ctx.Done():
-			loadErr = errors.Wrap(ctx.Err(), "plugin loading cancelled")
+	for i := range pluginConfig.Plugins {
+		if loadErr = plugins.prepare(&pluginConfig.Plugins[i]); loadErr != nil {
 			return nil, loadErr
-		default:
-			pluginLogger := logger.With("plugin", pluginConfig.GetName())
-			pluginLogger.Debug("loading plugin", "path", pluginConfig.Path, "kind", pluginConfig.Kind)
+		}
+	}
+
+	if cfg.HostServices != nil {
+		if !pluginConfig.TLS.CanIssue() {
+			logger.Error("host services require a CA key to issue certificates")
+			loadErr = errors.New("host services require ca_key_path when use_custom_tls is set")
+			return nil, loadErr
+		}
+		resources.Host, err = pluginrunner.NewHostServer(cfg.HostServices, transportGenerator)
+		if err != nil {
+			logger.Error("failed to start host server", "error", err)
+			loadErr = errors.Wrap(err, "failed to start host server")
+			return nil, loadErr
+		}
+	}
+
+	parallelism := pluginConfig.GetMaxParallelLoads()
+	logger.Debug("loading plugins", "parallelism", parallelism)
+
+	var (
+		wg             sync.WaitGroup
+		resultMu       sync.Mutex
+		requiredFailed bool
+		loadErrs       = LoadErrors{}
+	)
+	sem := make(chan struct{}, parallelism)
+	report := make(LoadReport, len(pluginConfig.Plugins))
+
+	for i, pluginConfig := range pluginConfig.Plugins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			name := pluginConfig.GetName()
+			required := pluginConfig.IsRequired()
+			pluginLogger := logger.With("plugin", name, "required", required)
+			report[i] = LoadResult{Name: name, Required: required}
+
+			fail := func(err error) {
+				resultMu.Lock()
+				defer resultMu.Unlock()
+				report[i].Err = err
+				loadErrs[name] = err
+				if required {
+					requiredFailed = true
+				}
+			}
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				fail(errors.Wrap(ctx.Err(), "plugin loading cancelled"))
+				return
+			}
 
-			plugin, err := pluginrunner.LoadPlugin(ctx, pluginConfig, transportGenerator, &cfg, portMgr)
+			// Plugins waiting for a slot are not started once a required one
+			// has failed, they would be closed right away.
+			resultMu.Lock()
+			skip := requiredFailed
+			resultMu.Unlock()
+			if skip {
+				pluginLogger.Debug("skipping plugin after load error")
+				return
+			}
+
+			pluginLogger.Debug("loading plugin", "path", pluginConfig.Path, "kind", pluginConfig.Kind)
+			start := time.Now()
+			plugin := pluginrunner.NewPlugin(ctx, pluginConfig, &cfg, resources)
+			err := plugins.start(name, plugin)
+			report[i].Duration = time.Since(start)
 			if err != nil {
-				pluginLogger.Error("failed to load plugin", "error", err)
-				loadErr = errors.Wrapf(err, "failed to load plugin %s", pluginConfig.GetName())
-				return nil, loadErr
+				plugins.stopStarting(name)
+				if required {
+					pluginLogger.Error("failed to load plugin", "error", err)
+				} else {
+					pluginLogger.Warn("failed to load optional plugin", "error", err)
+				}
+				fail(err)
+				return
 			}
 
-			plugins.pluginsMap[pluginConfig.GetName()] = plugin
+			plugins.mu.Lock()
+			delete(plugins.starting, name)
+			plugins.pluginsMap[name] = plugin
+			plugins.mu.Unlock()
 			pluginLogger.Info("plugin loaded successfully")
-		}
+		}()
+	}
+	wg.Wait()
+
+	if requiredFailed {
+		loadErr = errors.Wrap(loadErrs, "failed to load plugins")
+		return nil, loadErr
+	}
+	plugins.report = report
+
+	if cfg.Manifest.Watch {
+		plugins.startWatch(ctx, fingerprint)
+	}
+
+	if len(loadErrs) > 0 {
+		logger.Warn("some optional plugins failed to load", "failed", len(loadErrs), "plugin_count", len(plugins.pluginsMap))
+		return plugins, nil
 	}
 
 	logger.Info("all plugins loaded successfully", "plugin_count", len(plugins.pluginsMap))
@@ -0,0 +1,39 @@
+package pluginsloader
+
+import "time"
+
+// LoadResult describes how loading a single plugin went.
+type LoadResult struct {
+	Name     string
+	Required bool
+	// Err is nil if the plugin was loaded.
+	Err      error
+	Duration time.Duration
+}
+
+// LoadReport lists the outcome of every plugin in the manifest, in manifest
+// order.
+type LoadReport []LoadResult
+
+// Failed returns the errors of the plugins that could not be loaded, keyed by
+// plugin name.
+func (r LoadReport) Failed() LoadErrors {
+	failed := LoadErrors{}
+	for _, result := range r {
+		if result.Err != nil {
+			failed[result.Name] = result.Err
+		}
+	}
+	return failed
+}
+
+// LoadReport returns the outcome of loading each plugin of the manifest.
+// Optional plugins that failed to load show up here with their error.
+func (l *LoadedPlugins[T]) LoadReport() LoadReport {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	report := make(LoadReport, len(l.report))
+	copy(report, l.report)
+	return report
+}
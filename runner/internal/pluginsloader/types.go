@@ -1,29 +1,64 @@
 package pluginsloader
 
 import (
+	"context"
 	"log/slog"
+	"os"
+	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
 
+	"github.com/trustdsh/grpc-plugin/internal/broker"
 	"github.com/trustdsh/grpc-plugin/internal/transport"
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"github.com/trustdsh/grpc-plugin/pkgs/metadata"
 	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner"
-	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner/portmanager"
 )
 
 // LoadedPlugins represents a collection of loaded plugins with their associated resources
 type LoadedPlugins[T any] struct {
-	pluginsMap         map[string]*pluginrunner.LoadedPlugin[T]
+	pluginsMap map[string]*pluginrunner.LoadedPlugin[T]
+	// starting holds plugins waiting to become ready. They are only visible
+	// to Status and Statuses and move to pluginsMap once ready.
+	starting           map[string]*pluginrunner.LoadedPlugin[T]
 	TransportGenerator *transport.TransportGenerator
 	logger             *slog.Logger
-	portManager        *portmanager.PortManager
+	resources          *pluginrunner.Resources
+	report             LoadReport
 	mu                 sync.RWMutex
+
+	// ctx, cfg and the manifest settings are what LoadAll was called with,
+	// plugins loaded later run with the same settings. transport follows a
+	// watched manifest.
+	ctx           context.Context
+	cfg           *config.Config[T]
+	transport     string
+	buildCacheDir string
+	// cancels stop plugins loaded after LoadAll, keyed by plugin name.
+	cancels map[string]context.CancelFunc
+	closed  bool
+	// opMu serialises Load, Unload, Reload and manifest reconciliation.
+	opMu sync.Mutex
+
+	watchCancel context.CancelFunc
+	watchDone   chan struct{}
 }
 
-// Close shuts down all loaded plugins and releases their resources
+// Close shuts down all loaded plugins and releases their resources, see
+// CloseContext
 func (l *LoadedPlugins[T]) Close() error {
+	return l.CloseContext(context.Background())
+}
+
+// CloseContext shuts down all loaded plugins concurrently and releases their
+// resources. Plugins still running when ctx is done are killed.
+func (l *LoadedPlugins[T]) CloseContext(ctx context.Context) error {
+	l.stopWatch()
+
 	// Snapshot and clear under lock
 	l.mu.Lock()
+	l.closed = true
 	pluginsCopy := make(map[string]*pluginrunner.LoadedPlugin[T], len(l.pluginsMap))
 	for k, v := range l.pluginsMap {
 		pluginsCopy[k] = v
@@ -31,23 +66,47 @@ func (l *LoadedPlugins[T]) Close() error {
 	l.mu.Unlock()
 
 	l.logger.Debug("closing all plugins")
-	var lastErr error
+	var (
+		wg      sync.WaitGroup
+		errMu   sync.Mutex
+		lastErr error
+	)
 	for name, plugin := range pluginsCopy {
-		pluginLogger := l.logger.With("plugin", name)
-		pluginLogger.Debug("closing plugin")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pluginLogger := l.logger.With("plugin", name)
+			pluginLogger.Debug("closing plugin")
+
+			if err := plugin.CloseContext(ctx); err != nil {
+				pluginLogger.Error("failed to close plugin", "error", err)
+				errMu.Lock()
+				lastErr = err
+				errMu.Unlock()
+				return
+			}
 
-		if err := plugin.Close(); err != nil {
-			pluginLogger.Error("failed to close plugin", "error", err)
-			lastErr = err
-		}
+			pluginLogger.Debug("plugin closed successfully")
+		}()
+	}
+	wg.Wait()
 
-		if plugin.Server != nil && plugin.Server.Port != 0 {
-			if err := l.portManager.ReleasePort(plugin.Server.Port); err != nil {
-				pluginLogger.Error("failed to release port", "port", plugin.Server.Port, "error", err)
-			}
-		}
+	l.mu.Lock()
+	for _, cancel := range l.cancels {
+		cancel()
+	}
+	l.cancels = nil
+	l.mu.Unlock()
 
-		pluginLogger.Debug("plugin closed successfully")
+	// Calls still in flight when ctx is done are cut off.
+	if l.resources.Host != nil {
+		l.resources.Host.Stop(ctx)
+	}
+
+	if l.resources.SocketDir != "" {
+		if err := os.RemoveAll(l.resources.SocketDir); err != nil {
+			l.logger.Error("failed to remove socket directory", "dir", l.resources.SocketDir, "error", err)
+		}
 	}
 
 	if lastErr != nil {
@@ -58,7 +117,9 @@ func (l *LoadedPlugins[T]) Close() error {
 	return nil
 }
 
-// GetPlugin retrieves a plugin by name and returns its interface
+// GetPlugin retrieves a plugin by name and returns its interface. The client
+// is bound to the loaded plugin and stops working once it is unloaded or
+// reloaded, use Handle for a client that follows the plugin.
 func (l *LoadedPlugins[T]) GetPlugin(name string) (T, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -118,3 +179,56 @@ func (l *LoadedPlugins[T]) GetAllRawPlugins() []*pluginrunner.LoadedPlugin[T] {
 	l.logger.Debug("all raw plugins retrieved", "count", len(plugins))
 	return plugins
 }
+
+// Status returns the current state of the named plugin
+func (l *LoadedPlugins[T]) Status(name string) (pluginrunner.Status, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	plugin, ok := l.pluginsMap[name]
+	if !ok {
+		plugin, ok = l.starting[name]
+	}
+	if !ok {
+		l.logger.Error("plugin not found", "plugin", name)
+		return pluginrunner.Status{}, errors.Errorf("plugin %q not found", name)
+	}
+	return plugin.Status(), nil
+}
+
+// Statuses returns the current state of all loaded and starting plugins,
+// sorted by name
+func (l *LoadedPlugins[T]) Statuses() []pluginrunner.Status {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	statuses := make([]pluginrunner.Status, 0, len(l.pluginsMap)+len(l.starting))
+	for _, plugin := range l.pluginsMap {
+		statuses = append(statuses, plugin.Status())
+	}
+	for _, plugin := range l.starting {
+		statuses = append(statuses, plugin.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Describe asks the named plugin what it is: its version, build info, the
+// services it serves and its labels.
+func (l *LoadedPlugins[T]) Describe(ctx context.Context, name string) (*metadata.DescribeResponse, error) {
+	plugin, err := l.GetRawPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.Describe(ctx)
+}
+
+// Broker returns the broker of the named plugin's current instance, see
+// pluginrunner.LoadedPlugin.Broker.
+func (l *LoadedPlugins[T]) Broker(name string) (*broker.Broker, error) {
+	plugin, err := l.GetRawPlugin(name)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.Broker(), nil
+}
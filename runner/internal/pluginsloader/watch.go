@@ -0,0 +1,137 @@
+package pluginsloader
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/trustdsh/grpc-plugin/pkgs/config"
+)
+
+// startWatch starts polling the manifest for changes since it had the given
+// fingerprint. The watcher stops when ctx is done or the plugins are closed.
+func (l *LoadedPlugins[T]) startWatch(ctx context.Context, fingerprint []byte) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	l.watchCancel = cancel
+	l.watchDone = make(chan struct{})
+	go l.watch(watchCtx, fingerprint)
+}
+
+// stopWatch stops the manifest watcher, if any, and waits for it to return.
+func (l *LoadedPlugins[T]) stopWatch() {
+	if l.watchCancel == nil {
+		return
+	}
+	l.watchCancel()
+	<-l.watchDone
+}
+
+func (l *LoadedPlugins[T]) watch(ctx context.Context, last []byte) {
+	defer close(l.watchDone)
+
+	manifest := l.cfg.Manifest
+	logger := l.logger.With("manifest", manifest.Path)
+	interval := manifest.GetWatchInterval()
+	logger.Debug("watching manifest", "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("stopped watching manifest")
+			return
+		case <-ticker.C:
+		}
+
+		current, err := manifest.Fingerprint()
+		if err != nil {
+			// Editors may replace files, they show up again shortly.
+			logger.Debug("failed to read manifest", "error", err)
+			continue
+		}
+		if bytes.Equal(current, last) {
+			continue
+		}
+		last = current
+
+		logger.Info("manifest changed, reconciling plugins")
+		manifestConfig, err := config.LoadManifest(l.cfg)
+		if err != nil {
+			logger.Error("rejected manifest change", "error", err)
+			l.emit(config.ManifestEvent{Action: config.ManifestActionReject, Err: err})
+			continue
+		}
+		l.reconcile(ctx, manifestConfig)
+	}
+}
+
+// emit hands event to the manifest's OnEvent callback, if any.
+func (l *LoadedPlugins[T]) emit(event config.ManifestEvent) {
+	if l.cfg.Manifest.OnEvent != nil {
+		l.cfg.Manifest.OnEvent(event)
+	}
+}
+
+// reconcile unloads plugins that are no longer in manifestConfig, reloads
+// those whose entry changed and loads the new ones.
+func (l *LoadedPlugins[T]) reconcile(ctx context.Context, manifestConfig *config.ManifestConfig) {
+	l.opMu.Lock()
+	defer l.opMu.Unlock()
+
+	if !reflect.DeepEqual(manifestConfig.TLS, *l.resources.TLS) || manifestConfig.BuildCacheDir != l.buildCacheDir {
+		l.logger.Warn("TLS and build cache settings only change when the runner is restarted")
+	}
+	l.transport = manifestConfig.Transport
+
+	wanted := make(map[string]config.ManifestPlugin, len(manifestConfig.Plugins))
+	for _, plugin := range manifestConfig.Plugins {
+		name := plugin.GetName()
+		plugin.Transport = plugin.GetTransport(l.transport)
+		wanted[name] = plugin
+	}
+
+	l.mu.RLock()
+	running := make(map[string]config.ManifestPlugin, len(l.pluginsMap))
+	for name, plugin := range l.pluginsMap {
+		running[name] = plugin.Config()
+	}
+	l.mu.RUnlock()
+
+	var removed, changed, added []string
+	for name, current := range running {
+		plugin, ok := wanted[name]
+		switch {
+		case !ok:
+			removed = append(removed, name)
+		case !reflect.DeepEqual(plugin, current):
+			changed = append(changed, name)
+		}
+	}
+	for name := range wanted {
+		if _, ok := running[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(changed)
+	sort.Strings(added)
+
+	// Removed plugins go first so their names and paths can be reused.
+	for _, name := range removed {
+		_, err := l.unload(ctx, name)
+		l.emit(config.ManifestEvent{Action: config.ManifestActionUnload, Plugin: name, Err: err})
+	}
+	for _, name := range changed {
+		err := l.reload(ctx, name, wanted[name])
+		l.emit(config.ManifestEvent{Action: config.ManifestActionReload, Plugin: name, Err: err})
+	}
+	for _, name := range added {
+		err := l.load(ctx, wanted[name])
+		l.emit(config.ManifestEvent{Action: config.ManifestActionLoad, Plugin: name, Err: err})
+	}
+
+	l.logger.Info("manifest reconciled", "loaded", len(added), "unloaded", len(removed), "reloaded", len(changed))
+}
@@ -3,10 +3,50 @@ package runner
 import (
 	"context"
 
+	"github.com/trustdsh/grpc-plugin/internal/broker"
 	"github.com/trustdsh/grpc-plugin/pkgs/config"
+	"github.com/trustdsh/grpc-plugin/runner/internal/pluginrunner"
 	"github.com/trustdsh/grpc-plugin/runner/internal/pluginsloader"
 )
 
+// Status is a snapshot of a loaded plugin's state, see LoadedPlugins.Status.
+type Status = pluginrunner.Status
+
+// State is the lifecycle state of a loaded plugin.
+type State = pluginrunner.State
+
+const (
+	StateStarting   = pluginrunner.StateStarting
+	StateReady      = pluginrunner.StateReady
+	StateUnhealthy  = pluginrunner.StateUnhealthy
+	StateRestarting = pluginrunner.StateRestarting
+	StateStopped    = pluginrunner.StateStopped
+)
+
+// LoadReport lists how loading each plugin went, see LoadedPlugins.LoadReport.
+type LoadReport = pluginsloader.LoadReport
+
+// LoadResult is the outcome of loading a single plugin.
+type LoadResult = pluginsloader.LoadResult
+
+// Handle is a client for a plugin that survives restarts, upgrades and
+// reloads, see LoadedPlugins.Handle.
+type Handle[T any] = pluginsloader.Handle[T]
+
+// HandleOptions control how a Handle behaves while its plugin is not ready.
+type HandleOptions = pluginsloader.HandleOptions
+
+// Broker hands out numbered sub-connections to a plugin next to its main
+// connection, see LoadedPlugins.Broker.
+type Broker = broker.Broker
+
 func LoadAll[T any](ctx context.Context, cfg config.Config[T]) (*pluginsloader.LoadedPlugins[T], error) {
 	return pluginsloader.LoadAll(ctx, cfg)
 }
+
+// CallingPlugin returns the name of the plugin that made a call to a host
+// service, see config.Config.HostServices. ctx is the context the service
+// method was called with.
+func CallingPlugin(ctx context.Context) (string, bool) {
+	return pluginrunner.CallingPlugin(ctx)
+}